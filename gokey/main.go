@@ -136,7 +136,7 @@ func inputString() (string, error) {
 }
 
 func encryptPasswordInFile(hostsFile string, keyFile string) {
-	var re = regexp.MustCompile(`(?m)password: (["|'].*["|'])$`)
+	var re = regexp.MustCompile(`(?m)(?:password|passphrase): (["|'].*["|'])$`)
 	var matched []string
 	text := string(readFile(hostsFile))
 	for _, match := range re.FindAllStringSubmatch(text, -1) {
@@ -161,7 +161,7 @@ func encryptPasswordInFile(hostsFile string, keyFile string) {
 }
 
 func decryptPasswordInFile(hostsFile string, keyFile string) {
-	var re = regexp.MustCompile(`(?m)password: (["|'].*["|'])$`)
+	var re = regexp.MustCompile(`(?m)(?:password|passphrase): (["|'].*["|'])$`)
 	var matched []string
 	text := string(readFile(hostsFile))
 	for _, match := range re.FindAllStringSubmatch(text, -1) {
@@ -205,8 +205,8 @@ func showHelp() {
 gokey password encrypt / decrypt
 Usage:
 gokey generate          - Generate an encryption key
-gokey encrypt [<file>]  - Encrypt the passwords in a gorun hosts file
-gokey decrypt [<file>]  - Decrypt the passwords in a gorun hosts file
+gokey encrypt [<file>]  - Encrypt the passwords and key passphrases in a gorun hosts file
+gokey decrypt [<file>]  - Decrypt the passwords and key passphrases in a gorun hosts file
 `
 	fmt.Println(help)
 }
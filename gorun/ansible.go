@@ -0,0 +1,667 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Ansible export formats
+const (
+	ExportAnsible     = "ansible"
+	ExportAnsibleYaml = "ansible-yaml"
+)
+
+// ansibleSecretVars are never imported, the gorun passwords being encrypted in the hosts files
+var ansibleSecretVars = map[string]bool{
+	"ansible_password":        true,
+	"ansible_ssh_pass":        true,
+	"ansible_ssh_password":    true,
+	"ansible_become_password": true,
+	"ansible_become_pass":     true,
+}
+
+// ansibleHostRange matches the [01:10] or [a:f] ranges of the Ansible host names
+var ansibleHostRange = regexp.MustCompile(`\[([0-9]+|[a-z]):([0-9]+|[a-z])(?::([0-9]+))?\]`)
+
+// ansibleHostPort matches the port of the host:port INI host names
+var ansibleHostPort = regexp.MustCompile(`^(.*[^:]):([0-9]+)$`)
+
+// ansibleGroupInvalid matches the characters not allowed in the Ansible group names
+var ansibleGroupInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ansiblePlainKey matches the YAML keys written without quotes
+var ansiblePlainKey = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ansibleGroup pre-defined struct ; a group of an Ansible inventory
+// ------------------------------------
+type ansibleGroup struct {
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+// ansibleInventory pre-defined struct ; the groups, hosts and host vars of an Ansible inventory file
+// ------------------------------------
+type ansibleInventory struct {
+	fileName string
+	groups   map[string]*ansibleGroup
+	hosts    []string
+	hostVars map[string]map[string]string
+}
+
+func newAnsibleInventory(fileName string) *ansibleInventory {
+	return &ansibleInventory{
+		fileName: fileName,
+		groups:   make(map[string]*ansibleGroup),
+		hostVars: make(map[string]map[string]string),
+	}
+}
+
+func (inventory *ansibleInventory) getGroup(name string) *ansibleGroup {
+	group, ok := inventory.groups[name]
+	if !ok {
+		group = &ansibleGroup{vars: make(map[string]string)}
+		inventory.groups[name] = group
+	}
+	return group
+}
+
+// addHost adds the host to the group ; its vars add up over the lines declaring it
+func (inventory *ansibleInventory) addHost(groupName string, host string, vars map[string]string) {
+	group := inventory.getGroup(groupName)
+	if !containsLabel(group.hosts, host) {
+		group.hosts = append(group.hosts, host)
+	}
+	if _, ok := inventory.hostVars[host]; !ok {
+		inventory.hosts = append(inventory.hosts, host)
+		inventory.hostVars[host] = make(map[string]string)
+	}
+	for name, value := range vars {
+		inventory.hostVars[host][name] = value
+	}
+}
+
+// readAnsibleIniFile reads an Ansible INI inventory
+func readAnsibleIniFile(fileName string) (Nodes, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error: cannot read %v: %v", fileName, err)
+	}
+
+	inventory := newAnsibleInventory(fileName)
+	groupName, kind := "ungrouped", "hosts"
+	for number, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			groupName, kind = strings.Trim(line, "[]"), "hosts"
+			if name, suffix, ok := strings.Cut(groupName, ":"); ok {
+				groupName, kind = name, suffix
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("error: %v:%v: invalid section '%v'", fileName, number+1, line)
+			}
+			inventory.getGroup(groupName)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("error: %v:%v: expected name=value, got '%v'", fileName, number+1, line)
+			}
+			inventory.getGroup(groupName).vars[strings.TrimSpace(name)] = unquoteAnsibleValue(strings.TrimSpace(value))
+		case "children":
+			group := inventory.getGroup(groupName)
+			group.children = append(group.children, line)
+			inventory.getGroup(line)
+		default:
+			fields := splitAnsibleFields(line)
+			vars := make(map[string]string)
+			for _, field := range fields[1:] {
+				name, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return nil, fmt.Errorf("error: %v:%v: expected name=value, got '%v'", fileName, number+1, field)
+				}
+				vars[name] = unquoteAnsibleValue(value)
+			}
+			host := fields[0]
+			// host:port ; the IPv6 addresses have more than one colon, the ranges have theirs in brackets
+			if match := ansibleHostPort.FindStringSubmatch(host); match != nil && !strings.Contains(ansibleHostRange.ReplaceAllString(match[1], ""), ":") {
+				host = match[1]
+				vars["ansible_port"] = match[2]
+			}
+			for _, expanded := range expandAnsibleHostRange(host) {
+				inventory.addHost(groupName, expanded, vars)
+			}
+		}
+	}
+	return inventory.getNodes(), nil
+}
+
+// readAnsibleYaml reads an Ansible YAML inventory, the groups nesting from the all group ; it is parsed with
+// yaml.v3 rather than viper, which lowercases the host, group and var names
+func readAnsibleYaml(fileName string) (Nodes, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error: cannot read %v: %v", fileName, err)
+	}
+	var root map[string]interface{}
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("error: %v: %v", fileName, err)
+	}
+	inventory := newAnsibleInventory(fileName)
+	inventory.readYamlGroup("all", root["all"])
+	return inventory.getNodes(), nil
+}
+
+func (inventory *ansibleInventory) readYamlGroup(name string, value interface{}) {
+	group := inventory.getGroup(name)
+	content := getAnsibleMap(value)
+
+	for varName, varValue := range getAnsibleVars(content["vars"]) {
+		group.vars[varName] = varValue
+	}
+	hosts := getAnsibleMap(content["hosts"])
+	var hostNames []string
+	for host := range hosts {
+		hostNames = append(hostNames, host)
+	}
+	sort.Strings(hostNames)
+	for _, host := range hostNames {
+		for _, expanded := range expandAnsibleHostRange(host) {
+			inventory.addHost(name, expanded, getAnsibleVars(hosts[host]))
+		}
+	}
+	children := getAnsibleMap(content["children"])
+	var childNames []string
+	for child := range children {
+		childNames = append(childNames, child)
+	}
+	sort.Strings(childNames)
+	for _, child := range childNames {
+		group.children = append(group.children, child)
+		inventory.readYamlGroup(child, children[child])
+	}
+}
+
+// getAnsibleMap returns the YAML mapping with string keys ; the keys such as 10 or true are written back as text
+func getAnsibleMap(value interface{}) map[string]interface{} {
+	switch content := value.(type) {
+	case map[string]interface{}:
+		return content
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for key, keyValue := range content {
+			converted[fmt.Sprint(key)] = keyValue
+		}
+		return converted
+	}
+	return nil
+}
+
+// getAnsibleVars converts the YAML vars to strings ; the lists and maps keep their Go representation
+func getAnsibleVars(value interface{}) map[string]string {
+	vars := make(map[string]string)
+	for name, varValue := range getAnsibleMap(value) {
+		if varValue != nil {
+			vars[name] = fmt.Sprint(varValue)
+		}
+	}
+	return vars
+}
+
+// readAnsibleVarsFiles reads the vars of a group_vars or host_vars entry ; a YAML file, with or without
+// extension, or a folder of YAML files
+func readAnsibleVarsFiles(path string) map[string]string {
+	var files []string
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		entries, _ := os.ReadDir(path)
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); ext == ".yml" || ext == ".yaml" || ext == ".json" {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	} else {
+		for _, candidate := range []string{path, path + ".yml", path + ".yaml", path + ".json"} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				files = append(files, candidate)
+			}
+		}
+	}
+
+	vars := make(map[string]string)
+	for _, file := range files {
+		// JSON being YAML, yaml.v3 reads both and keeps the case of the var names
+		content, err := os.ReadFile(file)
+		var fileVars interface{}
+		if err == nil {
+			err = yaml.Unmarshal(content, &fileVars)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot read %v: %v\n", file, err)
+			continue
+		}
+		for name, value := range getAnsibleVars(fileVars) {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// getGroupDepths returns the depth of the groups under the all group, the groups without parent being its
+// children ; Ansible applies the group vars from the least to the most nested group
+func (inventory *ansibleInventory) getGroupDepths() map[string]int {
+	depths := make(map[string]int)
+	for name := range inventory.groups {
+		depths[name] = 1
+	}
+	depths["all"] = 0
+	// a children loop stops after as many passes as groups
+	for pass := 0; pass < len(inventory.groups); pass++ {
+		changed := false
+		for name, group := range inventory.groups {
+			for _, child := range group.children {
+				if depths[child] < depths[name]+1 {
+					depths[child] = depths[name] + 1
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return depths
+}
+
+// getHostGroups returns the groups of the host, its direct groups and their parents
+func (inventory *ansibleInventory) getHostGroups(host string) map[string]bool {
+	groups := make(map[string]bool)
+	var addParents func(name string)
+	addParents = func(name string) {
+		for parent, group := range inventory.groups {
+			if !groups[parent] && containsLabel(group.children, name) {
+				groups[parent] = true
+				addParents(parent)
+			}
+		}
+	}
+	for name, group := range inventory.groups {
+		if containsLabel(group.hosts, host) {
+			groups[name] = true
+			addParents(name)
+		}
+	}
+	return groups
+}
+
+// getNodes converts the inventory to gorun nodes ; the all group vars become the defaults, the other groups
+// vars and the host vars are merged per host, and the groups are declared in HostGroups
+func (inventory *ansibleInventory) getNodes() Nodes {
+	dir := filepath.Dir(inventory.fileName)
+	depths := inventory.getGroupDepths()
+	var groupNames []string
+	groupVars := make(map[string]map[string]string)
+	for name, group := range inventory.groups {
+		groupNames = append(groupNames, name)
+		groupVars[name] = make(map[string]string)
+		for varName, value := range group.vars {
+			groupVars[name][varName] = value
+		}
+		for varName, value := range readAnsibleVarsFiles(filepath.Join(dir, "group_vars", name)) {
+			groupVars[name][varName] = value
+		}
+	}
+	if _, ok := groupVars["all"]; !ok {
+		groupVars["all"] = readAnsibleVarsFiles(filepath.Join(dir, "group_vars", "all"))
+	}
+	sort.Slice(groupNames, func(i, j int) bool {
+		if depths[groupNames[i]] != depths[groupNames[j]] {
+			return depths[groupNames[i]] < depths[groupNames[j]]
+		}
+		return groupNames[i] < groupNames[j]
+	})
+
+	all := getAnsibleClient(groupVars["all"])
+	defaults := SSHDefaults{User: all.User, Port: all.Port, Key: all.Key, Become: all.Become != nil && *all.Become, Tags: all.Tags, Vars: all.Vars}
+
+	var nodes Nodes
+	fileGroup := strings.TrimSuffix(filepath.Base(inventory.fileName), filepath.Ext(inventory.fileName))
+	for _, host := range inventory.hosts {
+		vars := make(map[string]string)
+		hostGroups := inventory.getHostGroups(host)
+		for _, name := range groupNames {
+			if name == "all" || !hostGroups[name] {
+				continue
+			}
+			for varName, value := range groupVars[name] {
+				vars[varName] = value
+			}
+		}
+		for varName, value := range inventory.hostVars[host] {
+			vars[varName] = value
+		}
+		for varName, value := range readAnsibleVarsFiles(filepath.Join(dir, "host_vars", host)) {
+			vars[varName] = value
+		}
+
+		var node Node
+		node.Client = getAnsibleClient(vars)
+		node.Client.Server = host
+		node.Client.Defaults = defaults
+		node.Group = fileGroup
+		nodes = append(nodes, node)
+	}
+
+	// all is implicit in Ansible, in gorun it would only hold the hosts of the Ansible inventories
+	for _, name := range groupNames {
+		if name == "all" {
+			continue
+		}
+		group := inventory.groups[name]
+		key := strings.ToLower(name)
+		// an empty group is still declared, the children sections may name it
+		if _, ok := HostGroups[key]; !ok {
+			HostGroups[key] = []string{}
+		}
+		for _, host := range group.hosts {
+			HostGroups[key] = append(HostGroups[key], "^"+regexp.QuoteMeta(host)+"$")
+		}
+		for _, child := range group.children {
+			HostGroups[key] = append(HostGroups[key], "@"+child)
+		}
+	}
+	return nodes
+}
+
+// getAnsibleClient sets the connection fields named by the Ansible vars, the other vars being kept as vars
+func getAnsibleClient(vars map[string]string) SSH {
+	var sshClient SSH
+	for name, value := range vars {
+		switch name {
+		case "ansible_host", "ansible_ssh_host":
+			sshClient.hostName = value
+		case "ansible_port", "ansible_ssh_port":
+			sshClient.Port = value
+		case "ansible_user", "ansible_ssh_user":
+			sshClient.User = value
+		case "ansible_ssh_private_key_file", "ansible_private_key_file":
+			sshClient.Key = value
+		case "ansible_become":
+			if become, err := strconv.ParseBool(value); err == nil {
+				sshClient.Become = &become
+			}
+		case "gorun_tags":
+			sshClient.Tags = strings.Split(value, ",")
+		default:
+			if ansibleSecretVars[name] {
+				continue
+			}
+			if sshClient.Vars == nil {
+				sshClient.Vars = make(map[string]string)
+			}
+			sshClient.Vars[name] = value
+		}
+	}
+	return sshClient
+}
+
+// expandAnsibleHostRange expands the web[01:03] and db-[a:c] host names
+func expandAnsibleHostRange(host string) []string {
+	location := ansibleHostRange.FindStringSubmatchIndex(host)
+	if location == nil {
+		return []string{host}
+	}
+	match := ansibleHostRange.FindStringSubmatch(host)
+	prefix, suffix := host[:location[0]], host[location[1]:]
+	step := 1
+	if match[3] != "" {
+		step, _ = strconv.Atoi(match[3])
+		if step < 1 {
+			step = 1
+		}
+	}
+
+	var values []string
+	start, errStart := strconv.Atoi(match[1])
+	end, errEnd := strconv.Atoi(match[2])
+	if errStart == nil && errEnd == nil {
+		format := "%d"
+		if len(match[1]) > 1 && strings.HasPrefix(match[1], "0") {
+			format = fmt.Sprintf("%%0%dd", len(match[1]))
+		}
+		for i := start; i <= end; i += step {
+			values = append(values, fmt.Sprintf(format, i))
+		}
+	} else if errStart != nil && errEnd != nil {
+		for c := match[1][0]; c <= match[2][0]; c += byte(step) {
+			values = append(values, string(c))
+		}
+	} else {
+		return []string{host}
+	}
+
+	var hosts []string
+	for _, value := range values {
+		hosts = append(hosts, expandAnsibleHostRange(prefix+value+suffix)...)
+	}
+	return hosts
+}
+
+// splitAnsibleFields splits an INI host line on the spaces outside of the quotes
+func splitAnsibleFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			field.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			field.WriteRune(c)
+		case c == '#' && field.Len() == 0:
+			return fields
+		case c == ' ' || c == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+func unquoteAnsibleValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// exportAnsibleInventory writes the hosts as an Ansible inventory, in the INI or the YAML format ; the hosts are
+// grouped by hosts file and by the groups of the hosts files, and the passwords are never written
+func exportAnsibleInventory(writer io.Writer, format string, nodes Nodes) error {
+	if format != ExportAnsible && format != ExportAnsibleYaml {
+		return fmt.Errorf("error: unsupported export format '%v', expected %v or %v", format, ExportAnsible, ExportAnsibleYaml)
+	}
+
+	groups := make(map[string][]int)
+	for i, node := range nodes {
+		name := "ungrouped"
+		if node.Group != "" {
+			name = getAnsibleGroupName(node.Group)
+		}
+		groups[name] = append(groups[name], i)
+	}
+	selector := &hostSelector{hosts: nodes, groups: make(map[string]bool)}
+	for name := range HostGroups {
+		if name == "all" {
+			continue
+		}
+		set, err := selector.matchGroup("@" + name)
+		if err != nil || len(set) == 0 {
+			continue
+		}
+		key := getAnsibleGroupName(name)
+		for _, i := range set.getSortedIndexes() {
+			if !containsIndex(groups[key], i) {
+				groups[key] = append(groups[key], i)
+			}
+		}
+	}
+	var groupNames []string
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	// the host vars are written with the first group of the host
+	written := make(map[int]bool)
+	if format == ExportAnsible {
+		fmt.Fprintln(writer, "# Ansible inventory exported by gorun")
+		for _, name := range groupNames {
+			fmt.Fprintf(writer, "\n[%v]\n", name)
+			for _, i := range groups[name] {
+				line := nodes[i].Client.Server
+				if !written[i] {
+					for _, variable := range getAnsibleHostVars(nodes[i].Client) {
+						line = fmt.Sprintf("%v %v=%v", line, variable[0], quoteAnsibleValue(variable[1]))
+					}
+					written[i] = true
+				}
+				fmt.Fprintln(writer, line)
+			}
+		}
+		return nil
+	}
+
+	fmt.Fprintln(writer, "# Ansible inventory exported by gorun")
+	fmt.Fprintln(writer, "all:")
+	fmt.Fprintln(writer, "  children:")
+	for _, name := range groupNames {
+		fmt.Fprintf(writer, "    %v:\n", quoteAnsibleKey(name))
+		fmt.Fprintln(writer, "      hosts:")
+		for _, i := range groups[name] {
+			fmt.Fprintf(writer, "        %v:\n", quoteAnsibleKey(nodes[i].Client.Server))
+			if written[i] {
+				continue
+			}
+			for _, variable := range getAnsibleHostVars(nodes[i].Client) {
+				fmt.Fprintf(writer, "          %v: %v\n", quoteAnsibleKey(variable[0]), strconv.Quote(variable[1]))
+			}
+			written[i] = true
+		}
+	}
+	return nil
+}
+
+// getAnsibleHostVars returns the Ansible vars of the host, the connection ones first
+func getAnsibleHostVars(sshClient SSH) [][2]string {
+	sshClient = getAnsibleDefaultedClient(sshClient)
+	var variables [][2]string
+	if hostName := sshClient.getHostName(); hostName != sshClient.Server {
+		variables = append(variables, [2]string{"ansible_host", hostName})
+	}
+	if sshClient.Port != "" && sshClient.Port != "22" {
+		variables = append(variables, [2]string{"ansible_port", sshClient.Port})
+	}
+	if sshClient.User != "" {
+		variables = append(variables, [2]string{"ansible_user", sshClient.User})
+	}
+	if sshClient.Key != "" {
+		variables = append(variables, [2]string{"ansible_ssh_private_key_file", sshClient.Key})
+	}
+	if sshClient.Become != nil && *sshClient.Become {
+		variables = append(variables, [2]string{"ansible_become", "true"})
+	}
+	if len(sshClient.Tags) > 0 {
+		variables = append(variables, [2]string{"gorun_tags", strings.Join(sshClient.Tags, ",")})
+	}
+	var names []string
+	for name := range sshClient.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		variables = append(variables, [2]string{name, sshClient.Vars[name]})
+	}
+	return variables
+}
+
+// getAnsibleDefaultedClient applies the hosts file defaults to the host as initHosts does, the passwords aside,
+// Ansible having no hosts file defaults
+func getAnsibleDefaultedClient(sshClient SSH) SSH {
+	defaults := sshClient.Defaults
+	if sshClient.User == "" {
+		sshClient.User = defaults.User
+	}
+	if sshClient.Port == "" {
+		sshClient.Port = defaults.Port
+	}
+	if sshClient.Key == "" {
+		sshClient.Key = defaults.Key
+	}
+	if sshClient.Become == nil {
+		become := defaults.Become
+		sshClient.Become = &become
+	}
+	sshClient.Tags = appendUnique(append([]string{}, sshClient.Tags...), defaults.Tags)
+	vars := make(map[string]string)
+	for name, value := range defaults.Vars {
+		vars[name] = value
+	}
+	for name, value := range sshClient.Vars {
+		vars[name] = value
+	}
+	sshClient.Vars = vars
+	return sshClient
+}
+
+// getAnsibleGroupName returns a valid Ansible group name, made of letters, digits and underscores
+func getAnsibleGroupName(name string) string {
+	return ansibleGroupInvalid.ReplaceAllString(name, "_")
+}
+
+func quoteAnsibleValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t'\"#=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func quoteAnsibleKey(key string) string {
+	if ansiblePlainKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, existing := range indexes {
+		if existing == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandAnsibleHostRange(t *testing.T) {
+	tests := []struct {
+		host  string
+		hosts []string
+	}{
+		{"web1", []string{"web1"}},
+		{"web[1:3]", []string{"web1", "web2", "web3"}},
+		{"web[01:03].example.com", []string{"web01.example.com", "web02.example.com", "web03.example.com"}},
+		{"db-[a:c]", []string{"db-a", "db-b", "db-c"}},
+		{"web[0:6:3]", []string{"web0", "web3", "web6"}},
+		{"r[1:2]n[a:b]", []string{"r1na", "r1nb", "r2na", "r2nb"}},
+		{"web[3:1]", nil},
+		{"web[1:c]", []string{"web[1:c]"}},
+	}
+	for _, test := range tests {
+		hosts := expandAnsibleHostRange(test.host)
+		if !reflect.DeepEqual(hosts, test.hosts) {
+			t.Errorf("expandAnsibleHostRange(%q) = %q, expected %q", test.host, hosts, test.hosts)
+		}
+	}
+}
+
+func writeInventoryFile(t *testing.T, name string, content string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadAnsibleIniFileEmptyGroup(t *testing.T) {
+	HostGroups = make(map[string][]string)
+	defer func() { HostGroups = make(map[string][]string) }()
+	fileName := writeInventoryFile(t, "site.ini", `
+[web]
+web1
+web2
+
+[db]
+
+[prod:children]
+web
+db
+`)
+	nodes, err := readAnsibleIniFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := HostGroups["db"]; !ok {
+		t.Errorf("the empty group db is not declared")
+	}
+	selector := &hostSelector{hosts: nodes, groups: make(map[string]bool)}
+	set, err := selector.selectHosts("@prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 {
+		t.Errorf("@prod selected %v hosts, expected 2", len(set))
+	}
+}
+
+func TestReadAnsibleYamlKeepsCase(t *testing.T) {
+	HostGroups = make(map[string][]string)
+	defer func() { HostGroups = make(map[string][]string) }()
+	fileName := writeInventoryFile(t, "site.yaml", `
+all:
+  vars:
+    ansible_user: deploy
+  children:
+    WebServers:
+      vars:
+        appVersion: "1.2"
+      hosts:
+        Web1.Example.com:
+          ansible_port: 2222
+          listenPort: 8080
+        10:
+`)
+	nodes, err := readAnsibleYaml(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("read %v hosts, expected 2", len(nodes))
+	}
+	web := nodes[1]
+	if nodes[0].Client.Server == "Web1.Example.com" {
+		web = nodes[0]
+	}
+	if web.Client.Server != "Web1.Example.com" || web.Client.Port != "2222" || web.Client.Defaults.User != "deploy" {
+		t.Errorf("read the host %+v", web.Client)
+	}
+	expected := map[string]string{"appVersion": "1.2", "listenPort": "8080"}
+	if !reflect.DeepEqual(web.Client.Vars, expected) {
+		t.Errorf("read the vars %v, expected %v", web.Client.Vars, expected)
+	}
+	if _, ok := HostGroups["webservers"]; !ok {
+		t.Errorf("the group WebServers is not declared")
+	}
+}
+
+func TestExportAnsibleInventoryDefaults(t *testing.T) {
+	HostGroups = make(map[string][]string)
+	become := false
+	var nodes Nodes
+	for _, client := range []SSH{
+		{Server: "web1"},
+		{Server: "web2", User: "admin", Port: "2200", Become: &become},
+	} {
+		client.Defaults = SSHDefaults{User: "deploy", Port: "2222", Key: "~/.ssh/deploy", Become: true,
+			Vars: map[string]string{"dc": "rtp"}}
+		nodes = append(nodes, Node{Client: client, Group: "web"})
+	}
+
+	var output bytes.Buffer
+	if err := exportAnsibleInventory(&output, ExportAnsible, nodes); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"web1 ansible_port=2222 ansible_user=deploy ansible_ssh_private_key_file=~/.ssh/deploy ansible_become=true dc=rtp",
+		"web2 ansible_port=2200 ansible_user=admin ansible_ssh_private_key_file=~/.ssh/deploy dc=rtp",
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("the export misses %q:\n%v", line, output.String())
+		}
+	}
+}
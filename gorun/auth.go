@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Authentication methods used in the hosts files auth lists
+const (
+	AuthPassword = "password"
+	AuthKey      = "key"
+	AuthAgent    = "agent"
+)
+
+// defaultKeyFiles are tried by the key authentication when no key is set for the host
+var defaultKeyFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// AuthError is returned when no authentication method can be used or all of them were rejected
+type AuthError struct {
+	User    string
+	Address string
+	Methods []string
+	Reasons []string
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	message := fmt.Sprintf("error: authentication failed for %v@%v using [%v]", e.User, e.Address, strings.Join(e.Methods, ", "))
+	for _, reason := range e.Reasons {
+		message = message + " ; " + reason
+	}
+	if e.Err != nil {
+		message = message + " ; " + e.Err.Error()
+	}
+	return message
+}
+
+// getAuthMethodNames returns the ordered authentication chain of the host ; the auth list of the node
+// or of the hosts file defaults, else the one matching the AuthType mode
+func (sshClient *SSH) getAuthMethodNames(mode int) ([]string, error) {
+	if len(sshClient.Auth) > 0 {
+		return sshClient.Auth, nil
+	}
+	switch mode {
+	case 1:
+		return []string{AuthPassword}, nil
+	case 2:
+		return []string{AuthKey}, nil
+	case 3:
+		return []string{AuthAgent}, nil
+	case 4:
+		return []string{AuthPassword, AuthKey, AuthAgent}, nil
+	}
+	return nil, fmt.Errorf("error: does not support mode: %v", mode)
+}
+
+// getAuthMethods builds the ssh authentication chain of the host, with the reasons of the methods that could not be used
+func (sshClient *SSH) getAuthMethods(names []string) ([]ssh.AuthMethod, []string) {
+	var methods []ssh.AuthMethod
+	var reasons []string
+
+	for _, name := range names {
+		switch name {
+		case AuthPassword:
+			if sshClient.Password == "" {
+				reasons = append(reasons, "password: no password set")
+				continue
+			}
+			password := sshClient.Password
+			methods = append(methods, ssh.Password(password),
+				ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+					answers := make([]string, len(questions))
+					for i := range answers {
+						answers[i] = password
+					}
+					return answers, nil
+				}))
+
+		case AuthKey:
+			var signers []ssh.Signer
+			files := defaultKeyFiles
+			if sshClient.Key != "" {
+				files = []string{sshClient.Key}
+			}
+			for _, file := range files {
+				if sshClient.Key == "" {
+					if _, err := os.Stat(expandHome(file)); err != nil {
+						continue
+					}
+				}
+				signer, err := readPrivateKeyFile(expandHome(file), sshClient.Passphrase)
+				if err != nil {
+					reasons = append(reasons, fmt.Sprintf("key %v: %v", file, err))
+					continue
+				}
+				signers = append(signers, signer)
+			}
+			if len(signers) == 0 {
+				if sshClient.Key == "" {
+					reasons = append(reasons, "key: no key set and no default key found in ~/.ssh")
+				}
+				continue
+			}
+			methods = append(methods, ssh.PublicKeys(signers...))
+
+		case AuthAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				reasons = append(reasons, "agent: SSH_AUTH_SOCK is not set")
+				continue
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("agent: %v", err))
+				continue
+			}
+			sshClient.agentConn = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+
+		default:
+			reasons = append(reasons, fmt.Sprintf("%v: unknown authentication method", name))
+		}
+	}
+
+	return methods, reasons
+}
+
+// readPrivateKeyFile parses a private key, decrypting it with the passphrase when it is protected
+func readPrivateKeyFile(file string, passphrase string) (ssh.Signer, error) {
+	buffer, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase))
+	}
+	signer, err := ssh.ParsePrivateKey(buffer)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		return nil, errors.New("the key is protected, set its passphrase encrypted with gokey")
+	}
+	return signer, err
+}
+
+// isAuthRejected tells if a dial error comes from the server rejecting all the authentication methods
+func isAuthRejected(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
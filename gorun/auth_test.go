@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeTestKey writes an ed25519 private key file, protected when the passphrase is set
+func writeTestKey(t *testing.T, file string, passphrase string) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(privateKey, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAuthMethodNames(t *testing.T) {
+	tests := []struct {
+		auth  []string
+		mode  int
+		names []string
+		fails bool
+	}{
+		{nil, 1, []string{AuthPassword}, false},
+		{nil, 2, []string{AuthKey}, false},
+		{nil, 3, []string{AuthAgent}, false},
+		{nil, 4, []string{AuthPassword, AuthKey, AuthAgent}, false},
+		{[]string{AuthAgent, AuthKey}, 1, []string{AuthAgent, AuthKey}, false},
+		{[]string{AuthKey}, 0, []string{AuthKey}, false},
+		{nil, 0, nil, true},
+		{nil, 5, nil, true},
+	}
+	for _, test := range tests {
+		sshClient := SSH{Auth: test.auth}
+		names, err := sshClient.getAuthMethodNames(test.mode)
+		if (err != nil) != test.fails || !reflect.DeepEqual(names, test.names) {
+			t.Errorf("getAuthMethodNames(%v) with auth %q = %q, %v, expected %q", test.mode, test.auth, names, err, test.names)
+		}
+	}
+}
+
+func TestGetAuthMethods(t *testing.T) {
+	folder := t.TempDir()
+	emptyHome := filepath.Join(folder, "empty")
+	keyHome := filepath.Join(folder, "home")
+	writeTestKey(t, filepath.Join(keyHome, ".ssh", "id_ed25519"), "")
+	protectedKey := filepath.Join(folder, "protected")
+	writeTestKey(t, protectedKey, "passphrase")
+
+	// a password adds the password and keyboard-interactive methods
+	tests := []struct {
+		names   []string
+		client  SSH
+		home    string
+		socket  string
+		methods int
+		reasons []string
+	}{
+		{[]string{AuthPassword}, SSH{Password: "secret"}, emptyHome, "", 2, nil},
+		{[]string{AuthPassword}, SSH{}, emptyHome, "", 0, []string{"password: no password set"}},
+		{[]string{AuthKey}, SSH{}, keyHome, "", 1, nil},
+		{[]string{AuthKey}, SSH{}, emptyHome, "", 0, []string{"key: no key set"}},
+		{[]string{AuthKey}, SSH{Key: protectedKey, Passphrase: "passphrase"}, emptyHome, "", 1, nil},
+		{[]string{AuthKey}, SSH{Key: protectedKey}, emptyHome, "", 0, []string{"key " + protectedKey + ": the key is protected"}},
+		{[]string{AuthKey}, SSH{Key: protectedKey, Passphrase: "wrong"}, emptyHome, "", 0, []string{"key " + protectedKey + ": "}},
+		{[]string{AuthKey}, SSH{Key: filepath.Join(folder, "missing")}, keyHome, "", 0, []string{"key " + filepath.Join(folder, "missing") + ": "}},
+		{[]string{AuthAgent}, SSH{}, emptyHome, "", 0, []string{"agent: SSH_AUTH_SOCK is not set"}},
+		{[]string{AuthAgent}, SSH{}, emptyHome, filepath.Join(folder, "agent.sock"), 0, []string{"agent: dial unix"}},
+		{[]string{AuthPassword, AuthKey, AuthAgent}, SSH{Password: "secret"}, keyHome, "", 3, []string{"agent: SSH_AUTH_SOCK is not set"}},
+		{[]string{"kerberos"}, SSH{Password: "secret"}, emptyHome, "", 0, []string{"kerberos: unknown authentication method"}},
+	}
+	for _, test := range tests {
+		t.Setenv("HOME", test.home)
+		t.Setenv("SSH_AUTH_SOCK", test.socket)
+		sshClient := test.client
+		methods, reasons := sshClient.getAuthMethods(test.names)
+		matched := len(reasons) == len(test.reasons)
+		for i := 0; matched && i < len(reasons); i++ {
+			matched = strings.HasPrefix(reasons[i], test.reasons[i])
+		}
+		if len(methods) != test.methods || !matched {
+			t.Errorf("getAuthMethods(%q) with key %q = %v methods, reasons %q, expected %v methods, reasons %q",
+				test.names, test.client.Key, len(methods), reasons, test.methods, test.reasons)
+		}
+	}
+}
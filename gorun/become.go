@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// becomePrompt is the sudo password prompt answered by gorun ; distinctive enough not to show up in the command outputs
+const becomePrompt = "[gorun-sudo-password]:"
+
+// isBecome tells if the command runs through sudo ; set by the command, the hosts files or the --sudo option
+func isBecome(command Command, sshClient SSH) bool {
+	return command.Become || (sshClient.Become != nil && *sshClient.Become) || Config.Become
+}
+
+// getBecomeCommand wraps the command in sudo, with the prompt gorun watches for
+func getBecomeCommand(command string) string {
+	return fmt.Sprintf("sudo -p %v -- bash -c %v", shellQuote(becomePrompt), shellQuote(command))
+}
+
+// promptAnswerer captures the pty output, answers the sudo prompt with the host password
+// and removes the prompt and the line break sudo prints after reading the password
+type promptAnswerer struct {
+	lock     sync.Mutex
+	output   []byte
+	stdin    io.Writer
+	password string
+	prompts  int
+	strip    int
+	err      error
+}
+
+func (w *promptAnswerer) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.output = append(w.output, p...)
+	for {
+		if w.strip >= 0 && w.strip < len(w.output) {
+			switch w.output[w.strip] {
+			case '\r':
+				w.output = append(w.output[:w.strip], w.output[w.strip+1:]...)
+				continue
+			case '\n':
+				w.output = append(w.output[:w.strip], w.output[w.strip+1:]...)
+			}
+			w.strip = -1
+		}
+
+		i := bytes.Index(w.output, []byte(becomePrompt))
+		if i < 0 {
+			break
+		}
+		w.output = append(w.output[:i], w.output[i+len(becomePrompt):]...)
+		w.strip = i
+		w.prompts++
+		switch {
+		case w.password == "":
+			w.err = errors.New("error: sudo asked for a password and no password is set for the host")
+			io.WriteString(w.stdin, "\x03")
+		case w.prompts > 1:
+			w.err = errors.New("error: sudo rejected the password of the host")
+			io.WriteString(w.stdin, "\x03")
+		default:
+			io.WriteString(w.stdin, w.password+"\n")
+		}
+	}
+	return len(p), nil
+}
+
+// getOutput returns the captured output with the pty line endings converted
+func (w *promptAnswerer) getOutput() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	output := strings.ReplaceAll(string(w.output), "\r\n", "\n")
+	return strings.TrimSuffix(output, "\n")
+}
+
+// RunBecomeCommand runs the command through sudo on a pty, feeding the host password to the sudo prompt ;
+// the pty merges the stderr stream into the stdout one
+func (sshClient *SSH) RunBecomeCommand(command string) (string, string, error) {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	err := sshClient.session.RequestPty("xterm", 40, 200, modes)
+	if err != nil {
+		return "", "", err
+	}
+	stdin, err := sshClient.session.StdinPipe()
+	if err != nil {
+		return "", "", err
+	}
+
+	answerer := &promptAnswerer{stdin: stdin, password: sshClient.Password, strip: -1}
+	sshClient.session.Stdout = answerer
+	sshClient.session.Stderr = answerer
+	err = sshClient.session.Run(getBecomeCommand(command))
+
+	var stderr string
+	if answerer.err != nil {
+		stderr = answerer.err.Error()
+	}
+	return answerer.getOutput(), stderr, err
+}
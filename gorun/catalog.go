@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+)
+
+// Catalog matches kinds, from the weakest to the strongest
+const (
+	MatchNone = iota
+	MatchTypo
+	MatchPrefix
+	MatchExact
+)
+
+// CommandMatch pre-defined struct ; the commands files commands matched by the labels typed on the command line
+// ------------------------------------
+type CommandMatch struct {
+	Labels   string
+	Kind     int
+	Commands []Command
+	Params   []string
+}
+
+// resolveCommand matches the command line arguments against the commands labels. The arguments up to the first
+// name=value one are labels ; the longest exact labels win and the arguments after them are passed to the command,
+// then all the labels are tried as prefixes of the commands labels (cpu us), then as typos (memroy usage).
+func resolveCommand(arguments []string, commands []Command) CommandMatch {
+	var labelArguments []string
+	for _, argument := range arguments {
+		if strings.Contains(argument, "=") {
+			break
+		}
+		labelArguments = append(labelArguments, argument)
+	}
+	if len(labelArguments) == 0 {
+		return CommandMatch{}
+	}
+
+	for n := len(labelArguments); n > 0; n-- {
+		labels := strings.Join(labelArguments[:n], " ")
+		foundCommand, _, err := matchCommand(labels, commands)
+		if err == nil && foundCommand.Name != "" {
+			return CommandMatch{Labels: labels, Kind: MatchExact, Commands: []Command{foundCommand}, Params: arguments[n:]}
+		}
+	}
+
+	match := CommandMatch{Labels: strings.Join(labelArguments, " "), Params: arguments[len(labelArguments):]}
+	words := strings.Fields(match.Labels)
+	for _, kind := range []int{MatchPrefix, MatchTypo} {
+		var complete []Command
+		for _, command := range commands {
+			labels := strings.Fields(command.Name)
+			if !matchLabels(words, labels, kind) {
+				continue
+			}
+			match.Commands = append(match.Commands, command)
+			if len(labels) == len(words) {
+				complete = append(complete, command)
+			}
+		}
+		if len(match.Commands) > 0 {
+			match.Kind = kind
+			// docker cont picks docker containers over docker containers all
+			if len(match.Commands) > 1 && len(complete) == 1 {
+				match.Commands = complete
+			}
+			return match
+		}
+	}
+	return match
+}
+
+// isRunnable tells if the match selects the command to run ; only the full labels run it, the prefixes and the typos
+// listing the commands they may mean, as gorun prod install would otherwise run install prerequisites unasked
+func (match CommandMatch) isRunnable() bool {
+	return match.Kind == MatchExact && len(match.Commands) == 1
+}
+
+// matchLabels tells if every word matches a different label of the command
+func matchLabels(words []string, labels []string, kind int) bool {
+	used := make([]bool, len(labels))
+	for _, word := range words {
+		found := -1
+		for i, label := range labels {
+			if used[i] || !matchLabel(word, label, kind) {
+				continue
+			}
+			found = i
+			if strings.EqualFold(word, label) {
+				break
+			}
+		}
+		if found < 0 {
+			return false
+		}
+		used[found] = true
+	}
+	return true
+}
+
+// matchLabel tells if the word is the label, its prefix or, for a typo match, a misspelling of it ;
+// the words shorter than 4 letters are never taken for typos
+func matchLabel(word string, label string, kind int) bool {
+	word, label = strings.ToLower(word), strings.ToLower(label)
+	if strings.HasPrefix(label, word) {
+		return true
+	}
+	if kind != MatchTypo || len(word) < 4 {
+		return false
+	}
+	maxDistance := 1
+	if len(word) > 5 {
+		maxDistance = 2
+	}
+	return getEditDistance(word, label) <= maxDistance
+}
+
+// getEditDistance returns the Levenshtein distance between the two words
+func getEditDistance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(t)]
+}
+
+// filterCommands returns the commands whose labels, description or command contain every word of the filter,
+// the labels matching also by prefix or typo
+func filterCommands(commands []Command, filter string) []Command {
+	var filtered []Command
+	for _, command := range commands {
+		text := strings.ToLower(command.Name + " " + command.Description + " " + command.Command)
+		matched := true
+		for _, word := range strings.Fields(filter) {
+			if strings.Contains(text, strings.ToLower(word)) {
+				continue
+			}
+			if !matchLabels([]string{word}, strings.Fields(command.Name), MatchTypo) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			filtered = append(filtered, command)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveCommand(t *testing.T) {
+	commands := []Command{
+		{Name: "docker containers"},
+		{Name: "docker containers all"},
+		{Name: "docker images"},
+		{Name: "cpu usage"},
+		{Name: "memory usage"},
+		{Name: "disk usage"},
+		{Name: "install prerequisites"},
+		{Name: "info"},
+	}
+	tests := []struct {
+		arguments []string
+		kind      int
+		names     []string
+		params    []string
+	}{
+		{[]string{"cpu", "usage"}, MatchExact, []string{"cpu usage"}, nil},
+		{[]string{"usage", "cpu"}, MatchExact, []string{"cpu usage"}, nil},
+		{[]string{"disk", "usage", "path=/var"}, MatchExact, []string{"disk usage"}, []string{"path=/var"}},
+		{[]string{"disk", "usage", "-h"}, MatchExact, []string{"disk usage"}, []string{"-h"}},
+		{[]string{"cpu", "us"}, MatchPrefix, []string{"cpu usage"}, []string{}},
+		{[]string{"docker", "cont"}, MatchPrefix, []string{"docker containers"}, []string{}},
+		{[]string{"dock"}, MatchPrefix, []string{"docker containers", "docker containers all", "docker images"}, []string{}},
+		{[]string{"memroy", "usage"}, MatchTypo, []string{"memory usage"}, []string{}},
+		{[]string{"usage"}, MatchPrefix, []string{"cpu usage", "memory usage", "disk usage"}, []string{}},
+		{[]string{"cpx"}, MatchNone, nil, []string{}},
+		{[]string{"path=/var"}, MatchNone, nil, nil},
+		{[]string{"install"}, MatchPrefix, []string{"install prerequisites"}, []string{}},
+		{[]string{"in"}, MatchPrefix, []string{"info"}, []string{}},
+		{[]string{"info"}, MatchExact, []string{"info"}, nil},
+	}
+	for _, test := range tests {
+		match := resolveCommand(test.arguments, commands)
+		var names []string
+		for _, command := range match.Commands {
+			names = append(names, command.Name)
+		}
+		if match.Kind != test.kind || !reflect.DeepEqual(names, test.names) {
+			t.Errorf("resolveCommand(%q) = %v %q, expected %v %q", test.arguments, match.Kind, names, test.kind, test.names)
+		}
+		if len(match.Params) != len(test.params) || (len(test.params) > 0 && !reflect.DeepEqual(match.Params, test.params)) {
+			t.Errorf("resolveCommand(%q) params = %q, expected %q", test.arguments, match.Params, test.params)
+		}
+		// only the full labels run a command, even a single prefix match is listed
+		if match.isRunnable() != (test.kind == MatchExact) {
+			t.Errorf("resolveCommand(%q) runnable = %v", test.arguments, match.isRunnable())
+		}
+	}
+}
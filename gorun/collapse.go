@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// outputGroup pre-defined struct ; the hosts sharing the same output and return code
+// ------------------------------------
+type outputGroup struct {
+	Output string
+	Result CommandResult
+	Hosts  []string
+}
+
+var hostNumberRegex = regexp.MustCompile(`^(.*?)(\d+)(\D*)$`)
+
+// getHostLabel returns the host name used in the collapsed host lists ; the port is shown only if not 22
+func getHostLabel(sshClient SSH) string {
+	if sshClient.Port == "" || sshClient.Port == "22" {
+		return sshClient.Server
+	}
+	return fmt.Sprintf("%v:%v", sshClient.Server, sshClient.Port)
+}
+
+// groupOutputs groups the nodes by the hash of their output and return code,
+// with the largest groups first so the odd ones out are printed last
+func groupOutputs(sshClients Nodes) []outputGroup {
+	var groups []outputGroup
+	index := make(map[[sha256.Size]byte]int)
+	for _, node := range sshClients {
+		if node.Skipped {
+			continue
+		}
+		hash := sha256.Sum256([]byte(getResultCode(node.Result) + "\x00" + node.Output))
+		i, ok := index[hash]
+		if !ok {
+			i = len(groups)
+			index[hash] = i
+			groups = append(groups, outputGroup{Output: node.Output, Result: node.Result})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, getHostLabel(node.Client))
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
+
+// compactHostList folds host names differing only by their last number into ranges,
+// e.g. kick-ast-caas1.cisco.com ... kick-ast-caas8.cisco.com -> kick-ast-caas[1-8].cisco.com
+func compactHostList(hosts []string) string {
+	type hostRange struct {
+		prefix  string
+		suffix  string
+		width   int
+		numbers []int
+	}
+	var ranges []*hostRange
+	var plain []string
+	index := make(map[string]*hostRange)
+
+	for _, host := range hosts {
+		// the ranges number the server, web1:2222 and web2:2222 folding into web[1-2]:2222
+		name, port := host, ""
+		if i := strings.LastIndex(host, ":"); i > 0 && !strings.Contains(host[:i], ":") {
+			name, port = host[:i], host[i:]
+		}
+		match := hostNumberRegex.FindStringSubmatch(name)
+		if match == nil {
+			plain = append(plain, host)
+			continue
+		}
+		match[3] = match[3] + port
+		width := 0
+		if len(match[2]) > 1 && strings.HasPrefix(match[2], "0") {
+			width = len(match[2])
+		}
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			plain = append(plain, host)
+			continue
+		}
+		key := fmt.Sprintf("%v\x00%v\x00%v", match[1], match[3], width)
+		r, ok := index[key]
+		if !ok {
+			r = &hostRange{prefix: match[1], suffix: match[3], width: width}
+			index[key] = r
+			ranges = append(ranges, r)
+		}
+		r.numbers = append(r.numbers, number)
+	}
+
+	var parts []string
+	for _, r := range ranges {
+		sort.Ints(r.numbers)
+		var spans []string
+		for i := 0; i < len(r.numbers); {
+			j := i
+			for j+1 < len(r.numbers) && r.numbers[j+1] <= r.numbers[j]+1 {
+				j++
+			}
+			if r.numbers[i] == r.numbers[j] {
+				spans = append(spans, fmt.Sprintf("%0*d", r.width, r.numbers[i]))
+			} else {
+				spans = append(spans, fmt.Sprintf("%0*d-%0*d", r.width, r.numbers[i], r.width, r.numbers[j]))
+			}
+			i = j + 1
+		}
+		if len(spans) == 1 && !strings.Contains(spans[0], "-") {
+			parts = append(parts, r.prefix+spans[0]+r.suffix)
+		} else {
+			parts = append(parts, fmt.Sprintf("%v[%v]%v", r.prefix, strings.Join(spans, ","), r.suffix))
+		}
+	}
+	parts = append(parts, plain...)
+
+	return strings.Join(parts, ",")
+}
+
+func getCollapsedBanner(hosts string, count int, rc string) string {
+	var banner string
+	env := getEnvBannerPart()
+	x := strings.Repeat("-",
+		utf8.RuneCountInString(env)+
+			utf8.RuneCountInString(hosts)+
+			utf8.RuneCountInString(strconv.Itoa(count))+
+			utf8.RuneCountInString(rc)+
+			21)
+	banner = banner + fmt.Sprintf("%v\n", x)
+	banner = banner + fmt.Sprintf("| %v%v | hosts: %v | rc: %v |\n", env, hosts, count, rc)
+	banner = banner + fmt.Sprintf("%v\n", x)
+
+	return banner
+}
+
+// printCollapsedOutput prints each distinct output once, headed by the compacted list of hosts producing it
+func printCollapsedOutput(sshClients Nodes) {
+	for _, group := range groupOutputs(sshClients) {
+		banner := getCollapsedBanner(compactHostList(group.Hosts), len(group.Hosts), getResultCode(group.Result))
+		if group.Result.Status == StatusPassed {
+			fmt.Printf("%v%v\n\n", Green(banner), Default(group.Output))
+		} else {
+			fmt.Printf("%v%v\n\n", Red(banner), Black(group.Output))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupOutputs(t *testing.T) {
+	passed := CommandResult{Status: StatusPassed}
+	failed := CommandResult{ReturnCode: 1, Status: StatusFailed}
+	nodes := Nodes{
+		{Client: SSH{Server: "web1", Port: "22"}, Output: "ok", Result: passed},
+		{Client: SSH{Server: "web2", Port: "22"}, Output: "disk full", Result: failed},
+		{Client: SSH{Server: "web3", Port: "2222"}, Output: "ok", Result: passed},
+		{Client: SSH{Server: "web4", Port: "22"}, Output: "ok", Result: failed},
+		{Client: SSH{Server: "web5", Port: ""}, Output: "ok", Result: passed},
+		{Client: SSH{Server: "web6", Port: "22"}, Output: "ok", Result: passed, Skipped: true},
+		{Client: SSH{Server: "web7", Port: "22"}, Output: "disk full", Result: failed},
+	}
+	expected := []outputGroup{
+		{Output: "ok", Result: passed, Hosts: []string{"web1", "web3:2222", "web5"}},
+		{Output: "disk full", Result: failed, Hosts: []string{"web2", "web7"}},
+		{Output: "ok", Result: failed, Hosts: []string{"web4"}},
+	}
+	groups := groupOutputs(nodes)
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("groupOutputs = %+v, expected %+v", groups, expected)
+	}
+}
+
+func TestCompactHostList(t *testing.T) {
+	tests := []struct {
+		hosts   []string
+		compact string
+	}{
+		{[]string{"web1"}, "web1"},
+		{[]string{"web3", "web1", "web2"}, "web[1-3]"},
+		{[]string{"web1", "web2", "web4", "web5", "web7"}, "web[1-2,4-5,7]"},
+		{[]string{"caas1.cisco.com", "caas2.cisco.com", "caas8.cisco.com"}, "caas[1-2,8].cisco.com"},
+		{[]string{"db01", "db02", "db03", "db1"}, "db[01-03],db1"},
+		{[]string{"web1", "db1", "web2"}, "web[1-2],db1"},
+		{[]string{"proxy", "web1", "gateway"}, "web1,proxy,gateway"},
+		{[]string{"web1:2222", "web2:2222", "web3"}, "web[1-2]:2222,web3"},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3:2222"}, "10.0.0.[1-2],10.0.0.3:2222"},
+		{[]string{"fe80::1", "fe80::2"}, "fe80::[1-2]"},
+		{[]string{"web1", "web1"}, "web1"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		compact := compactHostList(test.hosts)
+		if compact != test.compact {
+			t.Errorf("compactHostList(%q) = %q, expected %q", test.hosts, compact, test.compact)
+		}
+	}
+}
@@ -13,6 +13,7 @@ var (
 	Magenta = Color("\033[1;35m%s\033[0m")
 	Teal    = Color("\033[1;36m%s\033[0m")
 	White   = Color("\033[1;37m%s\033[0m")
+	Reverse = Color("\033[1;7m%s\033[0m")
 )
 
 // Color function
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completeCommand is the hidden command the completion scripts call with the command line typed so far
+const completeCommand = "__complete"
+
+// completionScripts are the completion scripts printed by the completion command, per shell
+var completionScripts = map[string]string{
+	"bash": `# bash completion for scriptName ; load it with : source <(scriptName completion bash)
+_scriptName_completion() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local IFS=$'\n'
+	COMPREPLY=($(scriptName __complete bash "${COMP_LINE:0:COMP_POINT}" "$cur" 2>/dev/null))
+	if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == *= ]]; then
+		compopt -o nospace
+	fi
+}
+complete -o default -F _scriptName_completion scriptName
+`,
+	"zsh": `#compdef scriptName
+# zsh completion for scriptName ; load it with : source <(scriptName completion zsh)
+_scriptName_completion() {
+	local -a candidates
+	candidates=(${(f)"$(scriptName __complete zsh "${(j: :)words[1,CURRENT]}" 2>/dev/null)"})
+	compadd -S '' -- ${(M)candidates:#*=}
+	compadd -- ${candidates:#*=}
+}
+compdef _scriptName_completion scriptName
+`,
+	"fish": `# fish completion for scriptName ; load it with : scriptName completion fish | source
+complete -c scriptName -f -a '(scriptName __complete fish (commandline -cp) 2>/dev/null)'
+`,
+}
+
+// runCompletion prints the completion script of a shell, or the candidates of the word being completed.
+// The candidates come from the hosts and the commands files and from the cached inventories only ; the passwords
+// are not decrypted and no connection is opened.
+func runCompletion(scriptName string, args []string) {
+	if args[0] == "completion" {
+		script, ok := completionScripts[strings.Join(args[1:], " ")]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error: usage: %v completion bash|zsh|fish\n", scriptName)
+			return
+		}
+		fmt.Print(strings.ReplaceAll(script, "scriptName", scriptName))
+		return
+	}
+	if len(args) < 3 {
+		return
+	}
+	shell, line := args[1], args[2]
+
+	// the files errors are printed on stdout, where they would be taken for candidates
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	os.Stdout = devNull
+	SkipDecrypt = true
+	CachedInventoryOnly = true
+	Config, _ = loadConfig(nil)
+	if Config.SSHConfigFile != "" {
+		UserSSHConfig, _ = readSSHConfigFile(Config.SSHConfigFile)
+	}
+	hosts, _ := readAllHostsFilesInFolder(Config.HostsFolder, Config.HostsFile)
+	commands, _ := readAllCommandsFilesInFolder(Config.CommandsFolder)
+	os.Stdout = stdout
+	devNull.Close()
+
+	words, quoted := splitCompletionLine(line)
+	token := words[len(words)-1]
+	candidates := getCompletions(words, quoted, hosts, commands)
+
+	// bash completes the text after the last of its word breaks, such as the = of name=value or the : of host:port,
+	// its current word being the break itself right after it
+	strip := 0
+	if shell == "bash" && len(args) > 3 {
+		word := strings.TrimLeft(args[3], `'"=:`)
+		if strings.HasSuffix(token, word) {
+			strip = len(token) - len(word)
+		}
+	}
+	for _, candidate := range candidates {
+		fmt.Println(candidate[strip:])
+	}
+}
+
+// splitCompletionLine splits the command line as the shell does and tells if the last word is in open quotes ;
+// the last word is the one being completed, empty after a space
+func splitCompletionLine(line string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inWord = true, true
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ';' || c == '|' || c == '&':
+			// a new shell command starts
+			words, inWord = nil, false
+			word.Reset()
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	words = append(words, word.String())
+	return words, quote != 0
+}
+
+// getCompletions returns the candidates of the last word : an option, a host expression, a pseudo-command,
+// a command label or a command parameter
+func getCompletions(words []string, quoted bool, hosts Nodes, commands []Command) []string {
+	if len(words) < 2 {
+		return nil
+	}
+	token := words[len(words)-1]
+	typed := words[1 : len(words)-1]
+
+	var positional []string
+	valueOption := ""
+	for i := 0; i < len(typed); i++ {
+		if isRemoteCommandStart(positional) {
+			positional = append(positional, typed[i:]...)
+			break
+		}
+		if cliValueOptions[typed[i]] {
+			if i+1 == len(typed) {
+				valueOption = typed[i]
+			}
+			i++
+			continue
+		}
+		if name, _, ok := strings.Cut(typed[i], "="); cliBoolOptions[typed[i]] || (ok && cliValueOptions[name]) {
+			continue
+		}
+		positional = append(positional, typed[i])
+	}
+
+	var candidates []string
+	switch {
+	case valueOption == "--output":
+		for format := range outputFormats {
+			if format != "" {
+				candidates = append(candidates, format)
+			}
+		}
+	case valueOption == "--env":
+		candidates = getProfileNames(Config.Profiles)
+	case valueOption != "":
+		return nil
+	case strings.HasPrefix(token, "-") && (len(positional) < 2 || !isRemoteCommandStart(positional[:2])):
+		for option := range cliValueOptions {
+			candidates = append(candidates, option)
+		}
+		for option := range cliBoolOptions {
+			candidates = append(candidates, option)
+		}
+		if len(positional) == 1 {
+			candidates = append(candidates, cliCommands...)
+		}
+	case len(positional) == 0:
+		candidates = getHostCompletions(token, hosts)
+	case len(positional) > 1 && strings.HasPrefix(positional[1], "--"):
+		if positional[1] == "--commands" {
+			candidates = getLabelCompletions(nil, false, commands)
+		} else if positional[1] == "--export" && len(positional) == 2 {
+			candidates = []string{ExportAnsible, ExportAnsibleYaml}
+		}
+	default:
+		candidates = getLabelCompletions(positional[1:], quoted || strings.Contains(token, " "), commands)
+	}
+	return filterCompletions(candidates, token)
+}
+
+// getHostCompletions returns the servers, the @groups and the tags, completing the last atom of a host expression
+func getHostCompletions(token string, hosts Nodes) []string {
+	prefix := ""
+	if i := strings.LastIndexAny(token, ",&!()"); i >= 0 {
+		prefix = token[:i+1]
+	}
+	var atoms []string
+	for _, host := range hosts {
+		atoms = append(atoms, host.Client.Server)
+		if host.Group != "" {
+			atoms = append(atoms, "@"+host.Group)
+		}
+		for _, tag := range appendUnique(append([]string{}, host.Client.Tags...), host.Client.Defaults.Tags) {
+			if !strings.Contains(tag, "=") {
+				tag = "tag=" + tag
+			}
+			atoms = append(atoms, tag)
+		}
+	}
+	for name := range HostGroups {
+		atoms = append(atoms, "@"+name)
+	}
+	var candidates []string
+	for _, atom := range atoms {
+		candidates = append(candidates, prefix+atom)
+	}
+	return candidates
+}
+
+// getLabelCompletions returns the labels completing the typed ones, word by word or as whole quoted labels,
+// and the name= parameters of the command the typed labels select
+func getLabelCompletions(typed []string, quoted bool, commands []Command) []string {
+	var candidates []string
+	if quoted {
+		for _, command := range commands {
+			candidates = append(candidates, command.Name)
+		}
+		return candidates
+	}
+
+	var labels []string
+	hasParams := false
+	for _, word := range typed {
+		if strings.Contains(word, "=") {
+			hasParams = true
+			break
+		}
+		labels = append(labels, strings.Fields(word)...)
+	}
+
+	match := resolveCommand(typed, commands)
+	if match.isRunnable() {
+		for _, param := range match.Commands[0].Params {
+			switch {
+			case len(param.Enum) > 0:
+				for _, value := range param.Enum {
+					candidates = append(candidates, param.Name+"="+value)
+				}
+			case param.Type == ParamBool:
+				candidates = append(candidates, param.Name+"=true", param.Name+"=false")
+			default:
+				candidates = append(candidates, param.Name+"=")
+			}
+		}
+	}
+	if hasParams {
+		return candidates
+	}
+	for _, command := range commands {
+		commandLabels := strings.Fields(command.Name)
+		if !matchLabels(labels, commandLabels, MatchPrefix) {
+			continue
+		}
+		for _, label := range commandLabels {
+			if !containsLabel(labels, label) {
+				candidates = append(candidates, label)
+			}
+		}
+	}
+	return candidates
+}
+
+// containsLabel tells if the label is in the list, ignoring the case
+func containsLabel(labels []string, label string) bool {
+	for _, existing := range labels {
+		if strings.EqualFold(existing, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCompletions returns the sorted distinct candidates starting with the word being completed
+func filterCompletions(candidates []string, token string) []string {
+	var filtered []string
+	for _, candidate := range candidates {
+		if candidate != "" && strings.HasPrefix(candidate, token) {
+			filtered = appendUnique(filtered, []string{candidate})
+		}
+	}
+	sort.Strings(filtered)
+	return filtered
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCompletionLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		words  []string
+		quoted bool
+	}{
+		{"gorun ", []string{"gorun", ""}, false},
+		{"gorun we", []string{"gorun", "we"}, false},
+		{"gorun  web   cpu ", []string{"gorun", "web", "cpu", ""}, false},
+		{"gorun web 'cpu us", []string{"gorun", "web", "cpu us"}, true},
+		{`gorun web "cpu usage" me`, []string{"gorun", "web", "cpu usage", "me"}, false},
+		{`gorun web cpu\ us`, []string{"gorun", "web", "cpu us"}, false},
+		{"ls | gorun web", []string{"gorun", "web"}, false},
+	}
+	for _, test := range tests {
+		words, quoted := splitCompletionLine(test.line)
+		if !reflect.DeepEqual(words, test.words) || quoted != test.quoted {
+			t.Errorf("splitCompletionLine(%q) = %q, %v, expected %q, %v", test.line, words, quoted, test.words, test.quoted)
+		}
+	}
+}
+
+func TestGetCompletions(t *testing.T) {
+	registerConfigOptions()
+	HostGroups = map[string][]string{"dbs": {"db1"}}
+	defer func() { HostGroups = make(map[string][]string) }()
+	profiles := Config.Profiles
+	Config.Profiles = map[string]map[string]interface{}{"dev": {}, "prod": {}}
+	defer func() { Config.Profiles = profiles }()
+
+	hosts := Nodes{
+		{Client: SSH{Server: "web1", Tags: []string{"role=web"}}, Group: "webs"},
+		{Client: SSH{Server: "web2", Tags: []string{"canary"}}, Group: "webs"},
+		{Client: SSH{Server: "db1"}},
+	}
+	commands := []Command{
+		{Name: "cpu usage"},
+		{Name: "disk usage", Params: []CommandParam{{Name: "path"}, {Name: "human", Type: ParamBool}}},
+		{Name: "service restart", Params: []CommandParam{{Name: "name", Enum: []string{"nginx", "cron"}}}},
+	}
+	tests := []struct {
+		line        string
+		completions []string
+	}{
+		{"gorun we", []string{"web1", "web2"}},
+		{"gorun @", []string{"@dbs", "@webs"}},
+		{"gorun web1,d", []string{"web1,db1"}},
+		{"gorun '@webs&!ta", []string{"@webs&!tag=canary"}},
+		{"gorun @webs&!ta", nil},
+		{"gorun ro", []string{"role=web"}},
+		{"gorun web ", []string{"cpu", "disk", "restart", "service", "usage"}},
+		{"gorun web us", []string{"usage"}},
+		{"gorun web usage ", []string{"cpu", "disk"}},
+		{"gorun web cpu usage ", nil},
+		{"gorun web disk usage ", []string{"human=false", "human=true", "path="}},
+		{"gorun web service restart name=", []string{"name=cron", "name=nginx"}},
+		{"gorun web 'cpu", []string{"cpu usage"}},
+		{"gorun web --exec ls --", nil},
+		{"gorun web --col", []string{"--collapse"}},
+		{"gorun web --com", []string{"--command-default-timeout", "--commands", "--commands-folder"}},
+		{"gorun web cpu --col", nil},
+		{"gorun --output ", []string{"csv", "json", "junit", "ndjson", "text"}},
+		{"gorun --env p", []string{"prod"}},
+		{"gorun web --export ", []string{ExportAnsible, ExportAnsibleYaml}},
+		{"gorun web --commands ", []string{"cpu", "disk", "restart", "service", "usage"}},
+		{"gorun", nil},
+	}
+	for _, test := range tests {
+		words, quoted := splitCompletionLine(test.line)
+		completions := getCompletions(words, quoted, hosts, commands)
+		if !reflect.DeepEqual(completions, test.completions) {
+			t.Errorf("getCompletions(%q) = %q, expected %q", test.line, completions, test.completions)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// configDefaults are the built-in values of the Config fields, the first configuration layer
+var configDefaults = Configs{
+	CommandsFolder:        "commands",
+	HostsFolder:           "hosts",
+	HostsFile:             "*.yaml",
+	SSHDefaultTimeout:     10,
+	CommandDefaultTimeout: 300,
+	AuthType:              1,
+	SummaryDetails:        "failed-only",
+	Forks:                 20,
+	Output:                "text",
+	HostKeyChecking:       "tofu",
+	KnownHostsFile:        "~/.gorun/known_hosts",
+	SSHConfigFile:         "~/.ssh/config",
+	KeepAliveInterval:     30,
+	RetryAttempts:         3,
+	RetryBackoff:          1000,
+	RetryJitter:           500,
+	InventoryCacheTTL:     300,
+	KeyFile:               "~/.gorun/.config",
+}
+
+// configFiles are the configuration files layered over the defaults, the later ones winning
+var configFiles = []string{"/etc/gorun/config.yaml", "~/.gorun/config.yaml", "config.yaml"}
+
+// configPathFields are the Config fields holding a path, relative to the configuration file setting them
+var configPathFields = map[string]bool{
+	"HostsFolder":    true,
+	"CommandsFolder": true,
+	"KnownHostsFile": true,
+	"SSHConfigFile":  true,
+	"KeyFile":        true,
+}
+
+// configOptionAliases are the options setting a Config field under another name
+var configOptionAliases = map[string]string{
+	"--sudo": "Become",
+}
+
+// ConfigSources maps the Config fields to the layer their value comes from
+var ConfigSources = make(map[string]string)
+
+// registerConfigOptions adds an option for every Config field ; HostsFolder is set by --hosts-folder
+// and the profile is selected by --env. The Profiles are only set by the configuration files.
+func registerConfigOptions() {
+	configType := reflect.TypeOf(Configs{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		option := getConfigOption(field.Name)
+		if field.Type.Kind() == reflect.Map {
+			continue
+		}
+		if field.Type.Kind() == reflect.Bool {
+			cliBoolOptions[option] = true
+		} else {
+			cliValueOptions[option] = true
+		}
+	}
+}
+
+// loadConfig layers the configuration : the defaults, the configuration files, the --config file,
+// the profile selected by --env, GORUN_ENV or Env, the GORUN_* environment variables and the command line options
+func loadConfig(options map[string]string) (Configs, error) {
+	config := configDefaults
+	ConfigSources = make(map[string]string)
+	configType := reflect.TypeOf(config)
+	for i := 0; i < configType.NumField(); i++ {
+		ConfigSources[configType.Field(i).Name] = "default"
+	}
+
+	for _, fileName := range configFiles {
+		err := readConfigFile(&config, expandHome(fileName), false)
+		if err != nil {
+			return config, err
+		}
+	}
+	if fileName, ok := options["--config"]; ok {
+		err := readConfigFile(&config, expandHome(fileName), true)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	env := config.Env
+	if value, ok := os.LookupEnv(getConfigEnvName("Env")); ok {
+		env = value
+	}
+	if value, ok := options["--env"]; ok {
+		env = value
+	}
+	if env != "" {
+		err := applyProfile(&config, env)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	configValue := reflect.ValueOf(&config).Elem()
+	for i := 0; i < configType.NumField(); i++ {
+		name := configType.Field(i).Name
+		if configType.Field(i).Type.Kind() == reflect.Map {
+			continue
+		}
+		variable := getConfigEnvName(name)
+		if value, ok := os.LookupEnv(variable); ok {
+			if configPathFields[name] {
+				value = resolveConfigPath(value, "")
+			}
+			err := setConfigField(configValue.Field(i), value)
+			if err != nil {
+				return config, fmt.Errorf("error: invalid %v value '%v': %v", variable, value, err)
+			}
+			ConfigSources[name] = variable
+		}
+	}
+
+	for option, value := range options {
+		name, ok := configOptionAliases[option]
+		if !ok {
+			name = getConfigFieldName(strings.TrimPrefix(option, "--"), "-")
+		}
+		if name == "" {
+			continue
+		}
+		if configPathFields[name] {
+			value = resolveConfigPath(value, "")
+		}
+		err := setConfigField(configValue.FieldByName(name), value)
+		if err != nil {
+			return config, fmt.Errorf("error: invalid %v value '%v': %v", option, value, err)
+		}
+		ConfigSources[name] = option
+	}
+	return config, nil
+}
+
+// readConfigFile sets the Config fields present in the configuration file ; a missing file is skipped
+// unless it is required
+func readConfigFile(config *Configs, fileName string, required bool) error {
+	if _, err := os.Stat(fileName); err != nil {
+		if required || !os.IsNotExist(err) {
+			return fmt.Errorf("error: cannot read the configuration file %v: %v", fileName, err)
+		}
+		return nil
+	}
+
+	var viperRuntime = viper.New()
+	viperRuntime.SetConfigFile(fileName)
+	viperRuntime.SetConfigType("yaml")
+	if err := viperRuntime.ReadInConfig(); err != nil {
+		return fmt.Errorf("error: cannot read the configuration file %v: %v", fileName, err)
+	}
+	err := applyConfigLayer(config, viperRuntime, fileName, filepath.Dir(fileName))
+	if err != nil {
+		return fmt.Errorf("error: invalid configuration file %v: %v", fileName, err)
+	}
+	return nil
+}
+
+// applyConfigLayer sets the Config fields present in the layer, its relative paths being relative to the folder ;
+// the Profiles of the layer are added to the known ones, replacing the profiles of the same name
+func applyConfigLayer(config *Configs, viperRuntime *viper.Viper, source string, folder string) error {
+	var layer Configs
+	if err := viperRuntime.UnmarshalExact(&layer); err != nil {
+		return err
+	}
+
+	layerValue := reflect.ValueOf(layer)
+	configValue := reflect.ValueOf(config).Elem()
+	hasProfiles := false
+	for _, key := range viperRuntime.AllKeys() {
+		// the profiles keys are nested, profiles.prod.hostsfile, and merged once after the loop
+		key, _, _ = strings.Cut(key, ".")
+		name := getConfigFieldName(key, "")
+		if name == "" {
+			continue
+		}
+		if name == "Profiles" {
+			hasProfiles = true
+			continue
+		}
+		value := layerValue.FieldByName(name)
+		if path, ok := value.Interface().(string); ok && configPathFields[name] {
+			value = reflect.ValueOf(resolveConfigPath(path, folder))
+		}
+		configValue.FieldByName(name).Set(value)
+		ConfigSources[name] = source
+	}
+
+	if hasProfiles {
+		profiles := make(map[string]map[string]interface{})
+		for profile, keys := range config.Profiles {
+			profiles[profile] = keys
+		}
+		for profile, keys := range layer.Profiles {
+			for key, value := range keys {
+				if path, ok := value.(string); ok && configPathFields[getConfigFieldName(key, "")] {
+					keys[key] = resolveConfigPath(path, folder)
+				}
+			}
+			profiles[profile] = keys
+		}
+		config.Profiles = profiles
+		ConfigSources["Profiles"] = source
+	}
+	return nil
+}
+
+// resolveConfigPath expands the ~ and $VARIABLE paths and returns the relative path joined to the folder of the
+// configuration file setting it ; the environment variables and the options have no folder
+func resolveConfigPath(path string, folder string) string {
+	if strings.HasPrefix(path, "~") || strings.HasPrefix(path, "$") {
+		return expandHome(path)
+	}
+	if folder == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(folder, path)
+}
+
+// setConfigField parses the value of an environment variable or of an option into the Config field ;
+// the lists are comma separated
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("not an int")
+		}
+		field.SetInt(int64(number))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("not a bool")
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Kind())
+	}
+	return nil
+}
+
+// splitConfigFieldName splits a Config field name in its words ; SSHDefaultTimeout gives SSH, Default, Timeout
+// and InventoryURLs gives Inventory, URLs
+func splitConfigFieldName(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		lowerToUpper := unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+		acronymEnd := unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		plural := acronymEnd && runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+		if lowerToUpper || (acronymEnd && !plural) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// getConfigOption returns the command line option of a Config field, --ssh-default-timeout
+func getConfigOption(name string) string {
+	return "--" + strings.ToLower(strings.Join(splitConfigFieldName(name), "-"))
+}
+
+// getConfigEnvName returns the environment variable of a Config field, GORUN_SSH_DEFAULT_TIMEOUT
+func getConfigEnvName(name string) string {
+	return "GORUN_" + strings.ToUpper(strings.Join(splitConfigFieldName(name), "_"))
+}
+
+// getConfigFieldName returns the Config field named by a configuration key or an option, empty if none
+func getConfigFieldName(key string, separator string) string {
+	configType := reflect.TypeOf(Configs{})
+	for i := 0; i < configType.NumField(); i++ {
+		name := configType.Field(i).Name
+		if strings.EqualFold(strings.Join(splitConfigFieldName(name), separator), key) {
+			return name
+		}
+	}
+	return ""
+}
+
+// showConfig prints the effective value of every Config field with the layer it comes from
+func showConfig(config Configs) {
+	var lines []string
+	lines = append(lines, "FIELD\tVALUE\tSOURCE")
+	configType := reflect.TypeOf(config)
+	configValue := reflect.ValueOf(config)
+	for i := 0; i < configType.NumField(); i++ {
+		name := configType.Field(i).Name
+		value := fmt.Sprint(configValue.Field(i).Interface())
+		if values, ok := configValue.Field(i).Interface().([]string); ok {
+			value = strings.Join(values, ",")
+		}
+		if name == "Profiles" {
+			value = strings.Join(getProfileNames(config.Profiles), ",")
+		}
+		if value == "" {
+			value = `""`
+		}
+		lines = append(lines, fmt.Sprintf("%v\t%v\t%v", name, value, ConfigSources[name]))
+	}
+	printTabbedTable(lines)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitConfigFieldName(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+	}{
+		{"Forks", []string{"Forks"}},
+		{"HostsFolder", []string{"Hosts", "Folder"}},
+		{"SSHDefaultTimeout", []string{"SSH", "Default", "Timeout"}},
+		{"SSHConfigFile", []string{"SSH", "Config", "File"}},
+		{"InventoryURLs", []string{"Inventory", "URLs"}},
+		{"InventoryCacheTTL", []string{"Inventory", "Cache", "TTL"}},
+		{"MaxHosts", []string{"Max", "Hosts"}},
+	}
+	for _, test := range tests {
+		words := splitConfigFieldName(test.name)
+		if !reflect.DeepEqual(words, test.words) {
+			t.Errorf("splitConfigFieldName(%q) = %q, expected %q", test.name, words, test.words)
+		}
+	}
+	if option := getConfigOption("SSHDefaultTimeout"); option != "--ssh-default-timeout" {
+		t.Errorf("getConfigOption(SSHDefaultTimeout) = %v", option)
+	}
+	if variable := getConfigEnvName("InventoryURLs"); variable != "GORUN_INVENTORY_URLS" {
+		t.Errorf("getConfigEnvName(InventoryURLs) = %v", variable)
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	t.Setenv("HOME", "/home/ops")
+	t.Setenv("GORUN_TEST_ROOT", "/srv/gorun")
+	tests := []struct {
+		path     string
+		folder   string
+		resolved string
+	}{
+		{"hosts", "/etc/gorun", "/etc/gorun/hosts"},
+		{"hosts", "", "hosts"},
+		{"/opt/hosts", "/etc/gorun", "/opt/hosts"},
+		{"~/.gorun/hosts", "/etc/gorun", "/home/ops/.gorun/hosts"},
+		{"~/.gorun/hosts", "", "/home/ops/.gorun/hosts"},
+		{"$GORUN_TEST_ROOT/hosts", "", "/srv/gorun/hosts"},
+		{"", "/etc/gorun", ""},
+	}
+	for _, test := range tests {
+		resolved := resolveConfigPath(test.path, test.folder)
+		if resolved != test.resolved {
+			t.Errorf("resolveConfigPath(%q, %q) = %q, expected %q", test.path, test.folder, resolved, test.resolved)
+		}
+	}
+}
+
+func TestLoadConfigExpandsHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GORUN_HOSTS_FOLDER", "~/.gorun/hosts")
+	files := configFiles
+	configFiles = nil
+	defer func() { configFiles = files }()
+
+	config, err := loadConfig(map[string]string{"--commands-folder": "~/.gorun/commands"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HostsFolder != filepath.Join(home, ".gorun/hosts") {
+		t.Errorf("HostsFolder = %v", config.HostsFolder)
+	}
+	if config.CommandsFolder != filepath.Join(home, ".gorun/commands") {
+		t.Errorf("CommandsFolder = %v", config.CommandsFolder)
+	}
+
+	if _, err := loadConfig(map[string]string{"--env": "nope"}); err == nil {
+		t.Errorf("loadConfig with an unknown --env returned no error")
+	}
+}
+
+func TestLoadConfigProfilePaths(t *testing.T) {
+	folder := t.TempDir()
+	t.Setenv("HOME", folder)
+	files := configFiles
+	configFiles = nil
+	defer func() { configFiles = files }()
+
+	// a relative --config, its relative paths being joined to its relative folder
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(folder); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	configFile := filepath.Join("sub", "config.yaml")
+	content := `
+Forks: 5
+Profiles:
+  dev:
+    HostsFolder: devhosts
+    CommandsFolder: devcommands
+    KeyFile: ~/.gorun/.config
+    Forks: 2
+  prod:
+    HostsFolder: /srv/prod/hosts
+    MaxHosts: 10
+`
+	if err := os.MkdirAll(filepath.Dir(configFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env            string
+		hostsFolder    string
+		commandsFolder string
+		forks          int
+	}{
+		{"dev", filepath.Join("sub", "devhosts"), filepath.Join("sub", "devcommands"), 2},
+		{"prod", "/srv/prod/hosts", "commands", 5},
+	}
+	for _, test := range tests {
+		config, err := loadConfig(map[string]string{"--config": configFile, "--env": test.env})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.HostsFolder != test.hostsFolder || config.CommandsFolder != test.commandsFolder || config.Forks != test.forks {
+			t.Errorf("--env %v: HostsFolder %v, CommandsFolder %v, Forks %v, expected %v, %v, %v", test.env,
+				config.HostsFolder, config.CommandsFolder, config.Forks, test.hostsFolder, test.commandsFolder, test.forks)
+		}
+	}
+	config, _ := loadConfig(map[string]string{"--config": configFile, "--env": "dev"})
+	if config.KeyFile != filepath.Join(folder, ".gorun/.config") {
+		t.Errorf("--env dev: KeyFile %v", config.KeyFile)
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -23,23 +23,54 @@ type Configs struct {
 	SummaryDetails        string
 	SSHDefaultTimeout     int
 	CommandDefaultTimeout int
+	Forks                 int
+	Serial                string
+	AbortOnFail           bool
+	Output                string
+	Collapse              bool
+	HostKeyChecking       string
+	KnownHostsFile        string
+	SSHConfigFile         string
+	SSHConfigInventory    bool
+	KeepAliveInterval     int
+	RetryAttempts         int
+	RetryBackoff          int
+	RetryJitter           int
+	Become                bool
+	InventoryURLs         []string
+	InventoryCacheTTL     int
+	KeyFile               string
+	Confirm               bool
+	MaxHosts              int
+	Env                   string
+	Profiles              map[string]map[string]interface{}
 }
 
-// Config global instance containing the configuration provided in the config.yaml file
+// Config global instance containing the configuration layered from the defaults, the config.yaml files,
+// the GORUN_* environment variables and the command line options
 var Config Configs
 
+// SkipDecrypt keeps the passwords and passphrases encrypted, when the hosts files are read without connecting
+var SkipDecrypt bool
+
+// decrypt returns the password or passphrase encrypted by gokey with the key file ; a value which is not
+// base64 or a missing key file is an error, the callers going on without the password
 func decrypt(keyFile string, securemess string) (decodedmess string, err error) {
-	key := readFile(keyFile)
+	if SkipDecrypt {
+		return securemess, nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("error: cannot read the key file: %v", err)
+	}
 	cipherText, err := base64.StdEncoding.DecodeString(securemess)
 	if err != nil {
-		fmt.Printf("error: Password '%v' cannot be decrypted\n", securemess)
-		os.Exit(1)
+		return "", errors.New("error: the encrypted value is not base64 encoded")
 	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return "", fmt.Errorf("error: invalid key file %v: %v", keyFile, err)
 	}
 
 	if len(cipherText) < aes.BlockSize {
@@ -57,43 +88,31 @@ func decrypt(keyFile string, securemess string) (decodedmess string, err error)
 	return
 }
 
-func readFile(path string) []byte {
-	content, err := ioutil.ReadFile(path)
+func readAllCommandsFilesInFolder(folder string) ([]Command, error) {
+	var allCommands []Command
+
+	files, err := getCommandsFiles(folder)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	return content
-}
-
-func readConfigFile(fileName string) Configs {
-	var config Configs
-	var viperRuntime = viper.New()
-	viperRuntime.SetConfigFile(fileName) // name of config file
-	viperRuntime.SetConfigType("yaml")
-	viperRuntime.AddConfigPath("/etc/gorun/")   // path to look for the config file in
-	viperRuntime.AddConfigPath("$HOME/.gorun/") // call multiple times to add many search paths
-	viperRuntime.AddConfigPath(".")             // optionally look for config in the working directory
-	if err := viperRuntime.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Printf("Error finding yaml file: %s\n", err)
+	var messages []string
+	for _, file := range files {
+		commands, err := readCommandsYamlFile(file)
+		if err != nil {
+			messages = append(messages, err.Error())
 		}
-		fmt.Printf("Error reading yaml file: %s\n", err)
-		os.Exit(1)
-	}
-	err := viperRuntime.UnmarshalExact(&config)
-	if err != nil {
-		fmt.Printf("Unable to decode into struct, %v", err)
-		os.Exit(1)
+		allCommands = append(allCommands, commands...)
 	}
 
-	return config
+	if len(messages) > 0 {
+		return allCommands, errors.New(strings.Join(messages, "\n"))
+	}
+	return allCommands, nil
 }
 
-func readAllCommandsFilesInFolder(folder string) ([]Command, error) {
-	var allCommands []Command
+// getCommandsFiles returns the yaml files of the commands folder
+func getCommandsFiles(folder string) ([]string, error) {
 	var files []string
-
 	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
 		if filepath.Ext(path) == ".yaml" {
 			files = append(files, path)
@@ -104,15 +123,7 @@ func readAllCommandsFilesInFolder(folder string) ([]Command, error) {
 		err := errors.New(fmt.Sprintln("error: no yaml file found in folder ", folder))
 		return nil, err
 	}
-	for _, file := range files {
-		commands, err := readCommandsYamlFile(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-		}
-		allCommands = append(allCommands, commands...)
-	}
-
-	return allCommands, nil
+	return files, nil
 }
 
 func readCommandsYamlFile(fileName string) ([]Command, error) {
@@ -123,16 +134,20 @@ func readCommandsYamlFile(fileName string) ([]Command, error) {
 	viperRuntime.AddConfigPath("/etc/gorun/")   // path to look for the config file in
 	viperRuntime.AddConfigPath("$HOME/.gorun/") // call multiple times to add many search paths
 	viperRuntime.AddConfigPath(".")             // optionally look for config in the working directory
+	if filepath.IsAbs(fileName) {
+		// the search paths only find the relative file names, such as the ones of a ~/.gorun/hosts folder
+		viperRuntime.SetConfigFile(fileName)
+	}
 	if err := viperRuntime.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Printf("Error finding YAML file: %s\n", err)
-		}
-		fmt.Printf("Error reading YAML file: %s\n", err)
-		return nil, err
+		return nil, fmt.Errorf("error: cannot read the commands file %v: %v", fileName, err)
 	}
 	err := viperRuntime.UnmarshalKey("commands", &myStruct)
 	if err != nil {
-		fmt.Printf("Error parsing YAML file: %s\n", err)
+		return nil, fmt.Errorf("error: invalid commands file %v: %v", fileName, err)
+	}
+	// only the commands files commands are templates, the one time commands run as typed
+	for i := range myStruct {
+		myStruct[i].Template = true
 	}
 	return myStruct, nil
 }
@@ -140,71 +155,204 @@ func readCommandsYamlFile(fileName string) ([]Command, error) {
 func readAllHostsFilesInFolder(folder string, file string) (Nodes, error) {
 
 	var allNodes Nodes
-	var files []string
-	if file == "" || file == "*" || file == "*.yaml" {
-		err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
-			if filepath.Ext(path) == ".yaml" {
-				files = append(files, path)
-			}
-			return nil
-		})
+	files, inventories, missing, err := getHostsFiles(folder, file)
+	if err != nil {
+		return nil, err
+	}
+	// the hosts files errors fail the run as they fail gorun lint, the hosts read being still returned
+	var messages []string
+	for _, pattern := range missing {
+		messages = append(messages, fmt.Sprintf("error: no hosts file matches '%v' in folder %v", pattern, folder))
+	}
+
+	for _, file := range files {
+		var nodes Nodes
+		var err error
+		if filepath.Ext(file) == ".ini" {
+			nodes, err = readAnsibleIniFile(file)
+		} else {
+			nodes, err = readHostsYamlFile(file)
+		}
 		if err != nil {
-			err := errors.New(fmt.Sprintln("error: no yaml file found in folder ", folder))
-			return nil, err
+			messages = append(messages, err.Error())
 		}
-	} else {
-		filePath := fmt.Sprintf("%v/%v", folder, file)
-		files = append(files, filePath)
+		allNodes = append(allNodes, nodes...)
 	}
 
-	for _, file := range files {
-		nodes, err := readHostsYamlFile(file)
+	// a failed inventory leaves out its hosts, the other hosts still run
+	for _, inventory := range append(inventories, Config.InventoryURLs...) {
+		nodes, err := readInventory(inventory)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
+			fmt.Fprintf(os.Stderr, "warning: skipping the inventory '%v': %v\n", inventory, err)
+			continue
 		}
 		allNodes = append(allNodes, nodes...)
 	}
 
+	if Config.SSHConfigInventory {
+		for _, node := range readSSHConfigNodes() {
+			exists := false
+			for _, existingNode := range allNodes {
+				if existingNode.Client.Server == node.Client.Server {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				allNodes = append(allNodes, node)
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		return allNodes, errors.New(strings.Join(messages, "\n"))
+	}
 	return allNodes, nil
 }
 
+// getHostsFiles returns the hosts files and the inventory executables of the hosts folder selected by file,
+// all of them for "*.yaml", and the patterns of file matching none
+func getHostsFiles(folder string, file string) (files []string, inventories []string, missing []string, err error) {
+	if file == "" || file == "*" || file == "*.yaml" {
+		err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+			// a missing or unreadable hosts folder stops the walk
+			if err != nil {
+				return err
+			}
+			// the vars of the Ansible inventories are read with them
+			if info.IsDir() && (info.Name() == "group_vars" || info.Name() == "host_vars") {
+				return filepath.SkipDir
+			}
+			if filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".ini" {
+				files = append(files, path)
+			} else if isInventoryExecutable(info) {
+				inventories = append(inventories, path)
+			}
+			return nil
+		})
+		if err != nil {
+			err = fmt.Errorf("error: no yaml file found in folder %v: %v", folder, err)
+			return nil, nil, nil, err
+		}
+	} else {
+		// a comma separated list of files or patterns, such as the hosts files of a profile
+		for _, pattern := range strings.Split(file, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			matches, err := filepath.Glob(filepath.Join(folder, pattern))
+			if err != nil || len(matches) == 0 {
+				missing = append(missing, pattern)
+				continue
+			}
+			for _, path := range matches {
+				info, err := os.Stat(path)
+				if err == nil && isInventoryExecutable(info) && filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".ini" {
+					inventories = append(inventories, path)
+				} else {
+					files = append(files, path)
+				}
+			}
+		}
+	}
+	return files, inventories, missing, nil
+}
+
 func readHostsYamlFile(fileName string) (Nodes, error) {
 
-	var myStruct []SSH
-	var node Node
-	var nodes Nodes
 	var viperRuntime = viper.New()
-	var defaults SSHDefaults
 
 	viperRuntime.SetConfigName(fileName) // name of config file
 	viperRuntime.SetConfigType("yaml")
 	viperRuntime.AddConfigPath("/etc/gorun/")   // path to look for the config file in
 	viperRuntime.AddConfigPath("$HOME/.gorun/") // call multiple times to add many search paths
 	viperRuntime.AddConfigPath(".")             // optionally look for config in the working directory
+	if filepath.IsAbs(fileName) {
+		// the search paths only find the relative file names, such as the ones of a ~/.gorun/hosts folder
+		viperRuntime.SetConfigFile(fileName)
+	}
 	if err := viperRuntime.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Printf("Error finding YAML file: %s\n", err)
-		}
-		fmt.Printf("Error reading YAML file: %s\n", err)
-		return nodes, err
+		return nil, fmt.Errorf("error: cannot read the hosts file %v: %v", fileName, err)
 	}
 
+	if viperRuntime.IsSet("all") && !viperRuntime.IsSet("nodes") {
+		return readAnsibleYaml(viperRuntime.ConfigFileUsed())
+	}
+
+	// every hosts file is also a group named after the file
+	group := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	return readHostsConfig(viperRuntime, group)
+}
+
+// readHostsConfig reads the defaults, nodes, bastions, groups and vars of a hosts file or of an inventory ;
+// the vars apply to all its nodes, under the defaults vars
+func readHostsConfig(viperRuntime *viper.Viper, group string) (Nodes, error) {
+	var myStruct []SSH
+	var node Node
+	var nodes Nodes
+	var defaults SSHDefaults
+
 	err := viperRuntime.UnmarshalKey("defaults", &defaults)
 	if err != nil {
-		fmt.Printf("Error parsing YAML file: %s\n", err)
+		return nil, fmt.Errorf("error: invalid %v hosts: %v", group, err)
 	}
-	defaults.Password, err = decrypt(KeyFile, defaults.Password)
+	if defaults.Password != "" {
+		defaults.Password, err = decrypt(KeyFile, defaults.Password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	if defaults.Passphrase != "" {
+		defaults.Passphrase, err = decrypt(KeyFile, defaults.Passphrase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	var vars map[string]string
+	err = viperRuntime.UnmarshalKey("vars", &vars)
 	if err != nil {
-		fmt.Println(err)
+		return nil, fmt.Errorf("error: invalid %v hosts: %v", group, err)
+	}
+	for name, value := range vars {
+		if defaults.Vars == nil {
+			defaults.Vars = make(map[string]string)
+		}
+		if _, ok := defaults.Vars[name]; !ok {
+			defaults.Vars[name] = value
+		}
 	}
 
 	err = viperRuntime.UnmarshalKey("nodes", &myStruct)
 	if err != nil {
-		fmt.Printf("Error parsing YAML file: %s\n", err)
+		return nil, fmt.Errorf("error: invalid %v hosts: %v", group, err)
+	}
+
+	var bastions []SSH
+	err = viperRuntime.UnmarshalKey("bastions", &bastions)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid %v hosts: %v", group, err)
 	}
+	for i := 0; i < len(bastions); i++ {
+		bastions[i].Defaults = defaults
+		bastions[i].Defaults.Jump = ""
+		bastions[i].initHosts()
+		JumpHosts = append(JumpHosts, bastions[i])
+	}
+	var groups map[string][]string
+	err = viperRuntime.UnmarshalKey("groups", &groups)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid %v hosts: %v", group, err)
+	}
+	for name, members := range groups {
+		HostGroups[strings.ToLower(name)] = append(HostGroups[strings.ToLower(name)], members...)
+	}
+
 	for i := 0; i < len(myStruct); i++ {
 		node.Client = myStruct[i]
 		node.Client.Defaults = defaults
+		node.Group = group
 		nodes = append(nodes, node)
 	}
 
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAllHostsFilesInFolderErrors(t *testing.T) {
+	HostGroups = make(map[string][]string)
+	config := Config
+	defer func() { Config = config }()
+	Config.InventoryURLs = nil
+	Config.SSHConfigInventory = false
+
+	folder := t.TempDir()
+	files := map[string]string{
+		"good.yaml": "nodes:\n  - server: \"web1\"\n",
+		"bad.yaml":  "nodes: \"web2\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(folder, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file    string
+		servers string
+		message string
+	}{
+		{"good.yaml", "web1", ""},
+		{"*.yaml", "web1", "error: invalid bad hosts"},
+		{"good.yaml,none*.yaml", "web1", "error: no hosts file matches 'none*.yaml'"},
+	}
+	for _, test := range tests {
+		nodes, err := readAllHostsFilesInFolder(folder, test.file)
+		if getServers(nodes) != test.servers {
+			t.Errorf("%v: read the hosts %v, expected %v", test.file, getServers(nodes), test.servers)
+		}
+		if test.message == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", test.file, err)
+		}
+		if test.message != "" && (err == nil || !strings.HasPrefix(err.Error(), test.message)) {
+			t.Errorf("%v: error %v, expected %v", test.file, err, test.message)
+		}
+	}
+
+	if _, err := readAllHostsFilesInFolder(filepath.Join(folder, "missing"), ""); err == nil {
+		t.Errorf("a missing hosts folder returned no error")
+	}
+}
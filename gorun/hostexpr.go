@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxGroupDepth limits the nesting of the groups referencing other groups, which also stops the loops
+const maxGroupDepth = 16
+
+// HostGroups global instance containing the groups declared in the hosts files ; each group lists host expressions
+var HostGroups = make(map[string][]string)
+
+// hostSet maps the index of the selected hosts to the reasons they were selected for
+type hostSet map[int][]string
+
+// hostSelector evaluates a host expression against the hosts list
+//
+//	expr   := term { "," term }         union ; a "!" term after the first one removes its hosts from the terms before it
+//	term   := factor { "&" factor }     intersection
+//	factor := "!" factor | "(" expr ")" | atom
+//	atom   := @group | key=value | tag=value | server regex | ~server regex
+//
+// A server regex keeps the operators inside its (...), [...] and {...}, web(1|2) and db[0-9]{1,3} being single atoms ;
+// a regex starting with a group is prefixed with ~, ~(web|db)1, as a leading ( is a grouping of the expression.
+type hostSelector struct {
+	hosts  Nodes
+	tokens []string
+	pos    int
+	groups map[string]bool
+	depth  int
+}
+
+// tokenizeHostExpression splits a host expression into its operators and atoms ; the operators inside the
+// parentheses, brackets and braces of an atom, or escaped by a \, belong to the atom
+func tokenizeHostExpression(expression string) []string {
+	var tokens []string
+	atom := ""
+	depth, inClass, escaped := 0, false, false
+	flush := func() {
+		if atom = strings.TrimPrefix(strings.TrimSpace(atom), "~"); atom != "" {
+			tokens = append(tokens, atom)
+		}
+		atom = ""
+	}
+	for _, c := range expression {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '{' || (c == '(' && strings.TrimSpace(atom) != ""):
+			depth++
+		case depth > 0 && (c == ')' || c == '}'):
+			depth--
+		case depth == 0 && strings.ContainsRune(",&!()", c):
+			flush()
+			tokens = append(tokens, string(c))
+			continue
+		}
+		atom = atom + string(c)
+	}
+	flush()
+	return tokens
+}
+
+func (selector *hostSelector) peek() string {
+	if selector.pos < len(selector.tokens) {
+		return selector.tokens[selector.pos]
+	}
+	return ""
+}
+
+func (selector *hostSelector) next() string {
+	token := selector.peek()
+	selector.pos++
+	return token
+}
+
+// selectHosts evaluates the expression and returns the selected hosts indexes with their reasons
+func (selector *hostSelector) selectHosts(expression string) (hostSet, error) {
+	saved, savedPos := selector.tokens, selector.pos
+	defer func() { selector.tokens, selector.pos = saved, savedPos }()
+
+	selector.tokens = tokenizeHostExpression(expression)
+	selector.pos = 0
+	if len(selector.tokens) == 0 {
+		return nil, fmt.Errorf("error: empty host expression")
+	}
+	set, err := selector.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if selector.pos < len(selector.tokens) {
+		return nil, fmt.Errorf("error: unexpected '%v' in host expression '%v'", selector.peek(), expression)
+	}
+	return set, nil
+}
+
+func (selector *hostSelector) parseExpr() (hostSet, error) {
+	result := make(hostSet)
+	first := true
+	for {
+		exclude := !first && selector.peek() == "!"
+		if exclude {
+			selector.next()
+		}
+		term, err := selector.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		if exclude {
+			for i := range term {
+				delete(result, i)
+			}
+		} else {
+			result = unionHostSets(result, term)
+		}
+		first = false
+		if selector.peek() != "," {
+			return result, nil
+		}
+		selector.next()
+	}
+}
+
+func (selector *hostSelector) parseTerm() (hostSet, error) {
+	result, err := selector.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for selector.peek() == "&" {
+		selector.next()
+		factor, err := selector.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		result = intersectHostSets(result, factor)
+	}
+	return result, nil
+}
+
+func (selector *hostSelector) parseFactor() (hostSet, error) {
+	start := selector.pos
+	switch selector.peek() {
+	case "!":
+		selector.next()
+		factor, err := selector.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		reason := "!" + strings.Join(selector.tokens[start+1:selector.pos], "")
+		result := make(hostSet)
+		for i := range selector.hosts {
+			if _, ok := factor[i]; !ok {
+				result[i] = []string{reason}
+			}
+		}
+		return result, nil
+
+	case "(":
+		selector.next()
+		result, err := selector.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if selector.next() != ")" {
+			return nil, fmt.Errorf("error: missing ')' in host expression")
+		}
+		return result, nil
+
+	case "":
+		return nil, fmt.Errorf("error: missing host pattern at the end of the host expression")
+	case ",", "&", ")":
+		return nil, fmt.Errorf("error: missing host pattern before '%v'", selector.peek())
+	}
+	return selector.matchAtom(selector.next())
+}
+
+// matchAtom selects the hosts of a group, the hosts with a tag or the hosts whose server matches a regex
+func (selector *hostSelector) matchAtom(atom string) (hostSet, error) {
+	result := make(hostSet)
+
+	if strings.HasPrefix(atom, "@") {
+		return selector.matchGroup(atom)
+	}
+
+	if key, value, ok := strings.Cut(atom, "="); ok {
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		for i, host := range selector.hosts {
+			for _, tag := range host.Client.Tags {
+				if tag == key+"="+value || (key == "tag" && tag == value) {
+					result[i] = []string{atom}
+					break
+				}
+			}
+		}
+		return result, nil
+	}
+
+	pattern, err := regexp.Compile(atom)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid host pattern '%v': %v", atom, err)
+	}
+	for i, host := range selector.hosts {
+		if pattern.MatchString(host.Client.Server) {
+			result[i] = []string{atom}
+		}
+	}
+	return result, nil
+}
+
+// matchGroup selects the hosts of the file the group is named after and the hosts of its group expressions
+func (selector *hostSelector) matchGroup(atom string) (hostSet, error) {
+	name := strings.ToLower(strings.TrimPrefix(atom, "@"))
+	if selector.groups[name] || selector.depth >= maxGroupDepth {
+		return nil, fmt.Errorf("error: group '%v' references itself", name)
+	}
+
+	result := make(hostSet)
+	found := false
+	for i, host := range selector.hosts {
+		if strings.ToLower(host.Group) == name {
+			result[i] = []string{atom}
+			found = true
+		}
+	}
+	members, ok := HostGroups[name]
+	if !ok && !found {
+		return nil, fmt.Errorf("error: unknown group '%v'", name)
+	}
+
+	selector.groups[name] = true
+	selector.depth++
+	defer func() {
+		delete(selector.groups, name)
+		selector.depth--
+	}()
+	for _, member := range members {
+		set, err := selector.selectHosts(member)
+		if err != nil {
+			return nil, err
+		}
+		for i := range set {
+			result[i] = []string{atom}
+		}
+	}
+	return result, nil
+}
+
+func unionHostSets(a hostSet, b hostSet) hostSet {
+	result := make(hostSet)
+	for i, reasons := range a {
+		result[i] = append([]string{}, reasons...)
+	}
+	for i, reasons := range b {
+		result[i] = appendUnique(result[i], reasons)
+	}
+	return result
+}
+
+func intersectHostSets(a hostSet, b hostSet) hostSet {
+	result := make(hostSet)
+	for i, reasons := range a {
+		if other, ok := b[i]; ok {
+			result[i] = appendUnique(append([]string{}, reasons...), other)
+		}
+	}
+	return result
+}
+
+// appendUnique appends the values not already in the list
+func appendUnique(values []string, others []string) []string {
+	for _, other := range others {
+		exists := false
+		for _, value := range values {
+			if value == other {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			values = append(values, other)
+		}
+	}
+	return values
+}
+
+// getSortedIndexes returns the indexes of the set in the hosts files order
+func (set hostSet) getSortedIndexes() []int {
+	var indexes []int
+	for i := range set {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
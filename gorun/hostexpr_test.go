@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeHostExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		tokens     []string
+	}{
+		{"web1,web2", []string{"web1", ",", "web2"}},
+		{"@prod&role=db", []string{"@prod", "&", "role=db"}},
+		{"@prod,!web3", []string{"@prod", ",", "!", "web3"}},
+		{"(@a,@b)&!role=db", []string{"(", "@a", ",", "@b", ")", "&", "!", "role=db"}},
+		{"web(1|2)", []string{"web(1|2)"}},
+		{"db[0-9]{1,3}", []string{"db[0-9]{1,3}"}},
+		{"db[,&!()]x", []string{"db[,&!()]x"}},
+		{"web(1|2),db[0-9]{1,3}", []string{"web(1|2)", ",", "db[0-9]{1,3}"}},
+		{"(web(1|2),@db)", []string{"(", "web(1|2)", ",", "@db", ")"}},
+		{"~(web|db)1", []string{"(web|db)1"}},
+		{"~(web|db)1&!@prod", []string{"(web|db)1", "&", "!", "@prod"}},
+		{`web\,1`, []string{`web\,1`}},
+		{" web1 , web2 ", []string{"web1", ",", "web2"}},
+	}
+	for _, test := range tests {
+		tokens := tokenizeHostExpression(test.expression)
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("tokenizeHostExpression(%q) = %q, expected %q", test.expression, tokens, test.tokens)
+		}
+	}
+}
+
+func TestSelectHosts(t *testing.T) {
+	var hosts Nodes
+	for _, server := range []string{"web1", "web2", "web3", "db1", "db22", "db333", "db4444"} {
+		var node Node
+		node.Client.Server = server
+		node.Group = strings.TrimRight(server, "0123456789")
+		hosts = append(hosts, node)
+	}
+	tests := []struct {
+		expression string
+		servers    string
+	}{
+		{"web(1|2)", "web1,web2"},
+		{"^db[0-9]{1,3}$", "db1,db22,db333"},
+		{"web(1|2),db1", "web1,web2,db1"},
+		{"@web&!web(1|3)", "web2"},
+		{"~(web|db)1", "web1,db1"},
+		{"(@web,@db)&!~(web|db)[0-9]$", "db22,db333,db4444"},
+	}
+	for _, test := range tests {
+		selector := &hostSelector{hosts: hosts, groups: make(map[string]bool)}
+		set, err := selector.selectHosts(test.expression)
+		if err != nil {
+			t.Errorf("selectHosts(%q) failed: %v", test.expression, err)
+			continue
+		}
+		var servers []string
+		for _, i := range set.getSortedIndexes() {
+			servers = append(servers, hosts[i].Client.Server)
+		}
+		if strings.Join(servers, ",") != test.servers {
+			t.Errorf("selectHosts(%q) = %v, expected %v", test.expression, strings.Join(servers, ","), test.servers)
+		}
+	}
+}
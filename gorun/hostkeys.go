@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes
+const (
+	HostKeyStrict = "strict"
+	HostKeyTofu   = "tofu"
+	HostKeyOff    = "off"
+)
+
+// HostKeyError is returned when the host key of a server is unknown or differs from the known one
+type HostKeyError struct {
+	Address  string
+	Mismatch bool
+	Err      error
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Mismatch {
+		return fmt.Sprintf("error: host key mismatch for %v, possible spoofing ; %v", e.Address, e.Err)
+	}
+	return fmt.Sprintf("error: unknown host key for %v ; %v", e.Address, e.Err)
+}
+
+// knownHostsLock serializes the trust-on-first-use writes done by the parallel connections
+var knownHostsLock sync.Mutex
+
+// expandHome replaces a leading ~ with the user home folder
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), strings.TrimPrefix(path, "~"))
+	}
+	return os.ExpandEnv(path)
+}
+
+// getKnownHostsFiles returns the existing known_hosts files ; the user's ~/.ssh/known_hosts and the gorun managed one
+func getKnownHostsFiles() []string {
+	var files []string
+	for _, file := range []string{"~/.ssh/known_hosts", Config.KnownHostsFile} {
+		if file == "" {
+			continue
+		}
+		file = expandHome(file)
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// getHostKeyCallback returns the host key verification used by Connect, based on Config.HostKeyChecking.
+// Any verification error is also saved on the SSH struct, so it can be told apart from the dial errors.
+func (sshClient *SSH) getHostKeyCallback() (ssh.HostKeyCallback, []string, error) {
+	mode := Config.HostKeyChecking
+	if mode == "" {
+		mode = HostKeyTofu
+	}
+	if mode == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+	if mode != HostKeyStrict && mode != HostKeyTofu {
+		return nil, nil, fmt.Errorf("error: unsupported HostKeyChecking mode '%v'", mode)
+	}
+
+	knownHostsLock.Lock()
+	checkKnownHosts, err := knownhosts.New(getKnownHostsFiles()...)
+	knownHostsLock.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checkKnownHosts(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			sshClient.hostKeyErr = &HostKeyError{Address: hostname, Mismatch: true, Err: err}
+			return sshClient.hostKeyErr
+		}
+		if mode == HostKeyStrict {
+			sshClient.hostKeyErr = &HostKeyError{Address: hostname, Err: err}
+			return sshClient.hostKeyErr
+		}
+		return addKnownHost(hostname, key)
+	}
+
+	return callback, getKnownHostKeyAlgorithms(checkKnownHosts, sshClient.getAddress()), nil
+}
+
+// getKnownHostKeyAlgorithms returns the key types already known for an address, so the server
+// offers the recorded key instead of a different one that would look like a mismatch
+func getKnownHostKeyAlgorithms(checkKnownHosts ssh.HostKeyCallback, address string) []string {
+	var algorithms []string
+	var keyErr *knownhosts.KeyError
+	placeholder := &net.TCPAddr{IP: net.IPv4zero}
+	err := checkKnownHosts(address, placeholder, invalidPublicKey{})
+	if !errors.As(err, &keyErr) {
+		return algorithms
+	}
+	for _, known := range keyErr.Want {
+		keyType := known.Key.Type()
+		switch keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+	return algorithms
+}
+
+// addKnownHost records a new host key in the gorun managed known_hosts file
+func addKnownHost(hostname string, key ssh.PublicKey) error {
+	if Config.KnownHostsFile == "" {
+		return errors.New("error: KnownHostsFile is not set, cannot record the new host key")
+	}
+	file := expandHome(Config.KnownHostsFile)
+
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// invalidPublicKey is only used to look up the known keys of an address
+type invalidPublicKey struct{}
+
+func (invalidPublicKey) Type() string    { return "" }
+func (invalidPublicKey) Marshal() []byte { return []byte{} }
+func (invalidPublicKey) Verify([]byte, *ssh.Signature) error {
+	return errors.New("error: invalid public key")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestGetHostKeyCallback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer func() { Config.HostKeyChecking = ""; Config.KnownHostsFile = "" }()
+	knownKey := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+
+	tests := []struct {
+		mode     string
+		known    bool
+		key      ssh.PublicKey
+		fails    bool
+		mismatch bool
+		recorded bool
+	}{
+		{HostKeyOff, false, otherKey, false, false, false},
+		{HostKeyOff, true, otherKey, false, false, false},
+		{HostKeyStrict, false, otherKey, true, false, false},
+		{HostKeyStrict, true, knownKey, false, false, true},
+		{HostKeyStrict, true, otherKey, true, true, false},
+		{HostKeyTofu, false, otherKey, false, false, true},
+		{HostKeyTofu, true, knownKey, false, false, true},
+		{HostKeyTofu, true, otherKey, true, true, false},
+		{"", false, otherKey, false, false, true},
+	}
+	for _, test := range tests {
+		Config.HostKeyChecking = test.mode
+		Config.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+		if test.known {
+			line := knownhosts.Line([]string{knownhosts.Normalize("127.0.0.1:2222")}, knownKey) + "\n"
+			if err := os.WriteFile(Config.KnownHostsFile, []byte(line), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sshClient := SSH{Server: "127.0.0.1", Port: "2222"}
+		callback, algorithms, err := sshClient.getHostKeyCallback()
+		if err != nil {
+			t.Fatalf("%q: %v", test.mode, err)
+		}
+		if test.known && test.mode != HostKeyOff && !reflect.DeepEqual(algorithms, []string{ssh.KeyAlgoED25519}) {
+			t.Errorf("%q: the known host key algorithms are %q", test.mode, algorithms)
+		}
+
+		err = callback("127.0.0.1:2222", remote, test.key)
+		var hostKeyErr *HostKeyError
+		if (err != nil) != test.fails || (test.fails && (!errors.As(err, &hostKeyErr) || hostKeyErr.Mismatch != test.mismatch)) {
+			t.Errorf("%q with a known host %v: %v, expected failing %v with mismatch %v", test.mode, test.known, err, test.fails, test.mismatch)
+		}
+		if test.fails && sshClient.hostKeyErr == nil {
+			t.Errorf("%q with a known host %v: the host key error was not kept apart from the dial errors", test.mode, test.known)
+		}
+
+		content, _ := os.ReadFile(Config.KnownHostsFile)
+		recorded := strings.Contains(string(content), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(test.key))))
+		if recorded != test.recorded {
+			t.Errorf("%q with a known host %v: the key recorded is %v, known_hosts:\n%v", test.mode, test.known, recorded, string(content))
+		}
+	}
+
+	Config.HostKeyChecking = "ask"
+	sshClient := SSH{Server: "127.0.0.1", Port: "2222"}
+	if _, _, err := sshClient.getHostKeyCallback(); err == nil {
+		t.Errorf("the HostKeyChecking mode ask was accepted")
+	}
+}
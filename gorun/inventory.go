@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// inventoryTimeout bounds the run of an inventory executable and the fetch of an inventory URL
+const inventoryTimeout = 30 * time.Second
+
+// inventoryCacheFolder keeps the last output of each inventory, reused for InventoryCacheTTL seconds
+const inventoryCacheFolder = "~/.gorun/inventory"
+
+// CachedInventoryOnly reads the inventories from their cache only, without running or fetching them
+var CachedInventoryOnly bool
+
+// isInventoryExecutable tells if a file of the hosts folder is an inventory executable
+func isInventoryExecutable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+func isInventoryURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// readInventory returns the nodes of an inventory executable or URL ; they belong to the group named after
+// the executable or the last element of the URL path
+func readInventory(source string) (Nodes, error) {
+	content, err := getInventoryContent(source)
+	if err != nil {
+		return nil, err
+	}
+	viperRuntime := viper.New()
+	viperRuntime.SetConfigType("json")
+	err = viperRuntime.ReadConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory: %v", err)
+	}
+	nodes, err := readHostsConfig(viperRuntime, getInventoryName(source))
+	if err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "error: "))
+	}
+	return nodes, nil
+}
+
+// getInventoryName returns the group name of the inventory hosts
+func getInventoryName(source string) string {
+	name := source
+	if isInventoryURL(source) {
+		location, err := url.Parse(source)
+		if err != nil {
+			return source
+		}
+		name = path.Base(location.Path)
+		if name == "/" || name == "." {
+			return location.Hostname()
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+}
+
+// getInventoryContent returns the JSON of the inventory, from its cache while it is fresher than
+// InventoryCacheTTL ; when the inventory fails, a stale cache is used with a warning
+func getInventoryContent(source string) ([]byte, error) {
+	cacheFile := getInventoryCacheFile(source)
+	ttl := time.Duration(Config.InventoryCacheTTL) * time.Second
+	info, statErr := os.Stat(cacheFile)
+	if statErr == nil && (CachedInventoryOnly || time.Since(info.ModTime()) < ttl) {
+		content, err := os.ReadFile(cacheFile)
+		if err == nil {
+			return content, nil
+		}
+	}
+	if CachedInventoryOnly {
+		return nil, fmt.Errorf("not cached")
+	}
+
+	content, err := fetchInventory(source)
+	if err != nil {
+		if statErr != nil {
+			return nil, err
+		}
+		cached, cacheErr := os.ReadFile(cacheFile)
+		if cacheErr != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: inventory '%v': %v ; using its cache from %v\n", source, err,
+			info.ModTime().Format("2006-01-02 15:04:05"))
+		return cached, nil
+	}
+
+	if ttl > 0 {
+		err = os.MkdirAll(filepath.Dir(cacheFile), 0700)
+		if err == nil {
+			err = os.WriteFile(cacheFile, content, 0600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot cache the inventory '%v': %v\n", source, err)
+		}
+	}
+	return content, nil
+}
+
+// getInventoryCacheFile returns the cache file of the inventory, named after the hash of its path or URL
+func getInventoryCacheFile(source string) string {
+	if !isInventoryURL(source) {
+		if absolute, err := filepath.Abs(source); err == nil {
+			source = absolute
+		}
+	}
+	hash := sha256.Sum256([]byte(source))
+	return filepath.Join(expandHome(inventoryCacheFolder), hex.EncodeToString(hash[:8])+".json")
+}
+
+// fetchInventory runs the inventory executable with --list or gets the inventory URL, and checks it returned JSON
+func fetchInventory(source string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryTimeout)
+	defer cancel()
+
+	var content []byte
+	if isInventoryURL(source) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Accept", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("http status %v", response.Status)
+		}
+		content, err = io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var stderr bytes.Buffer
+		command := exec.CommandContext(ctx, source, "--list")
+		command.Stderr = &stderr
+		output, err := command.Output()
+		if err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return nil, fmt.Errorf("%v: %v", err, message)
+			}
+			return nil, err
+		}
+		content = output
+	}
+
+	if !json.Valid(content) {
+		return nil, fmt.Errorf("the output is not valid JSON")
+	}
+	return content, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func getServers(nodes Nodes) string {
+	var servers []string
+	for _, node := range nodes {
+		servers = append(servers, node.Client.Server)
+	}
+	return strings.Join(servers, ",")
+}
+
+func TestReadInventoryURL(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	Config.InventoryCacheTTL = 60
+	CachedInventoryOnly = false
+
+	var requests int32
+	var failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"nodes": [{"server": "web1"}, {"server": "web2"}]}`)
+	}))
+	defer server.Close()
+	source := server.URL + "/cloud"
+
+	// the first read fetches the inventory and caches it
+	nodes, err := readInventory(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getServers(nodes) != "web1,web2" || nodes[0].Group != "cloud" || requests != 1 {
+		t.Errorf("fetched %v in group %v after %v requests", getServers(nodes), nodes[0].Group, requests)
+	}
+
+	// a read within the TTL comes from the cache
+	nodes, err = readInventory(source)
+	if err != nil || getServers(nodes) != "web1,web2" || requests != 1 {
+		t.Errorf("cached read returned %v, %v after %v requests", getServers(nodes), err, requests)
+	}
+
+	// once the TTL expired, a failed fetch falls back to the stale cache with a warning
+	atomic.StoreInt32(&failing, 1)
+	stale := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(getInventoryCacheFile(source), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	warning := captureFile(t, &os.Stderr, func() {
+		nodes, err = readInventory(source)
+	})
+	if err != nil || getServers(nodes) != "web1,web2" || requests != 2 {
+		t.Errorf("stale read returned %v, %v after %v requests", getServers(nodes), err, requests)
+	}
+	if !strings.Contains(warning, "warning: inventory") || !strings.Contains(warning, "using its cache") {
+		t.Errorf("stale read warned %q", warning)
+	}
+
+	// without a cache the failure is returned
+	if _, err = readInventory(server.URL + "/other"); err == nil {
+		t.Errorf("a failed inventory without a cache returned no error")
+	}
+}
+
+func TestReadAllHostsFilesSkipsFailedInventory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	Config.InventoryCacheTTL = 0
+	CachedInventoryOnly = false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"nodes": [{"server": "db1"}]}`)
+	}))
+	defer server.Close()
+	Config.InventoryURLs = []string{server.URL + "/broken", server.URL + "/db"}
+	defer func() { Config.InventoryURLs = nil }()
+
+	var nodes Nodes
+	var err error
+	warning := captureFile(t, &os.Stderr, func() {
+		nodes, err = readAllHostsFilesInFolder(t.TempDir(), "")
+	})
+	if err != nil {
+		t.Fatalf("a failed inventory aborted the hosts: %v", err)
+	}
+	if getServers(nodes) != "db1" {
+		t.Errorf("read the hosts %v, expected db1", getServers(nodes))
+	}
+	if !strings.Contains(warning, "warning: skipping the inventory '"+server.URL+"/broken'") {
+		t.Errorf("the failed inventory warned %q", warning)
+	}
+}
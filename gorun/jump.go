@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxJumpHops limits the jump host chains, which also stops the chains looping through the bastions
+const maxJumpHops = 8
+
+// jumpErrorTTL is how long a failed bastion dial is shared by the targets behind it before it is dialed again
+const jumpErrorTTL = 2 * time.Second
+
+// JumpHosts global instance containing the bastions declared in the hosts files
+var JumpHosts []SSH
+
+// JumpError is returned when a host cannot be reached because one of its jump hosts failed
+type JumpError struct {
+	Hop string
+	Err error
+}
+
+func (e *JumpError) Error() string {
+	return fmt.Sprintf("error: jump host %v: %v", e.Hop, e.Err)
+}
+
+func (e *JumpError) Unwrap() error {
+	return e.Err
+}
+
+// jumpClient is a bastion connection shared by all the targets behind it and forgotten once it breaks ; a failed
+// bastion is dialed again by a later connection attempt of a target or once its failure is older than jumpErrorTTL,
+// so the targets dialing together share the failure and the later batches still retry the bastion
+type jumpClient struct {
+	lock    sync.Mutex
+	client  *ssh.Client
+	err     error
+	attempt int
+	dialed  time.Time
+}
+
+var jumpClients = make(map[string]*jumpClient)
+var jumpClientsLock sync.Mutex
+
+// jumpClientsByConn finds the shared bastion of a connection, to forget it when the connection breaks
+var jumpClientsByConn = make(map[*ssh.Client]*jumpClient)
+
+// parseJumpSpec splits a [user@]server[:port] jump host definition
+func parseJumpSpec(spec string) (string, string, string) {
+	var user, port string
+	server := spec
+	if i := strings.LastIndex(server, "@"); i >= 0 {
+		user = server[:i]
+		server = server[i+1:]
+	}
+	if host, p, err := net.SplitHostPort(server); err == nil {
+		server = host
+		port = p
+	}
+	return user, server, port
+}
+
+// getJumpHost returns the credentials used to connect to a jump host ; the matching bastion declared
+// in the hosts files, else the credentials of the target behind it
+func getJumpHost(spec string, target SSH) SSH {
+	user, server, port := parseJumpSpec(spec)
+	for _, bastion := range JumpHosts {
+		if bastion.Server != server || (port != "" && bastion.Port != port) || (user != "" && bastion.User != user) {
+			continue
+		}
+		return bastion
+	}
+
+	hop := SSH{Server: server, Port: port, User: user}
+	keyFromSSHConfig := hop.applySSHConfig()
+	hop.Password = target.Password
+	hop.Passphrase = target.Passphrase
+	if keyFromSSHConfig {
+		hop.Auth = []string{AuthKey, AuthPassword}
+	} else {
+		hop.Key = target.Key
+		hop.Auth = target.Auth
+	}
+	if hop.Port == "" {
+		hop.Port = "22"
+	}
+	if hop.User == "" {
+		hop.User = target.User
+	}
+	return hop
+}
+
+// getJumpHosts expands the jump definition of a host into the ordered list of hops to go through,
+// including the jump hosts of the first bastion
+func getJumpHosts(sshClient SSH, depth int) ([]SSH, error) {
+	var hops []SSH
+	if sshClient.Jump == "" || sshClient.Jump == "none" {
+		return hops, nil
+	}
+	for i, spec := range strings.Split(sshClient.Jump, ",") {
+		spec = strings.TrimSpace(spec)
+		hop := getJumpHost(spec, sshClient)
+		if i == 0 && hop.Jump != "" && hop.Jump != "none" {
+			if depth >= maxJumpHops {
+				return nil, fmt.Errorf("error: more than %v jump hosts to reach %v", maxJumpHops, sshClient.Server)
+			}
+			prefix, err := getJumpHosts(hop, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, prefix...)
+		}
+		hop.Jump = ""
+		hops = append(hops, hop)
+	}
+	if len(hops) > maxJumpHops {
+		return nil, fmt.Errorf("error: more than %v jump hosts to reach %v", maxJumpHops, sshClient.Server)
+	}
+	return hops, nil
+}
+
+// getJumpClient connects through the chain of hops and returns the connection to the last one ;
+// every hop is dialed once and then shared, unless it failed during an earlier attempt or jumpErrorTTL ago
+func getJumpClient(hops []SSH, attempt int) (*ssh.Client, error) {
+	var client *ssh.Client
+	key := ""
+	for _, hop := range hops {
+		key = key + ">" + hop.User + "@" + hop.getAddress()
+
+		jumpClientsLock.Lock()
+		jc, ok := jumpClients[key]
+		if !ok {
+			jc = &jumpClient{}
+			jumpClients[key] = jc
+		}
+		jumpClientsLock.Unlock()
+
+		jc.lock.Lock()
+		if jc.client == nil && (jc.err == nil || attempt > jc.attempt || time.Since(jc.dialed) > jumpErrorTTL) {
+			hop.via = client
+			jc.attempt = attempt
+			jc.err = hop.Connect(Config.AuthType)
+			jc.client = hop.client
+			jc.dialed = time.Now()
+			if jc.client != nil {
+				jumpClientsLock.Lock()
+				jumpClientsByConn[jc.client] = jc
+				jumpClientsLock.Unlock()
+				go watchJumpClient(jc.client)
+			}
+		}
+		err := jc.err
+		client = jc.client
+		jc.lock.Unlock()
+		if err != nil {
+			return nil, &JumpError{Hop: hop.User + "@" + hop.getAddress(), Err: err}
+		}
+	}
+	return client, nil
+}
+
+// dial opens the ssh connection to the host, directly or through its jump hosts
+func (sshClient *SSH) dial(sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	via := sshClient.via
+	if via == nil && sshClient.Jump != "" && sshClient.Jump != "none" {
+		hops, err := getJumpHosts(*sshClient, 0)
+		if err != nil {
+			return nil, err
+		}
+		via, err = getJumpClient(hops, sshClient.attempt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if via == nil {
+		return ssh.Dial("tcp", sshClient.getAddress(), sshConfig)
+	}
+
+	conn, err := via.Dial("tcp", sshClient.getAddress())
+	if err != nil {
+		// a bastion refusing the forward still serves the other hosts, a broken one is dialed again
+		var openErr *ssh.OpenChannelError
+		if !errors.As(err, &openErr) {
+			forgetJumpClient(via)
+		}
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, sshClient.getAddress(), sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// watchJumpClient forgets the bastion connection once it ends, sending it keepalives meanwhile ; a bastion not
+// answering them is closed, the next connection attempt dialing it again
+func watchJumpClient(client *ssh.Client) {
+	done := make(chan struct{})
+	if Config.KeepAliveInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(Config.KeepAliveInterval) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+						client.Close()
+						return
+					}
+				}
+			}
+		}()
+	}
+	client.Wait()
+	close(done)
+	forgetJumpClient(client)
+}
+
+// forgetJumpClient closes the bastion connection and drops it from the shared ones
+func forgetJumpClient(client *ssh.Client) {
+	jumpClientsLock.Lock()
+	jc, ok := jumpClientsByConn[client]
+	delete(jumpClientsByConn, client)
+	jumpClientsLock.Unlock()
+	client.Close()
+	if !ok {
+		return
+	}
+	jc.lock.Lock()
+	if jc.client == client {
+		jc.client = nil
+		jc.err = nil
+	}
+	jc.lock.Unlock()
+}
+
+// closeJumpClients closes the shared bastion connections
+func closeJumpClients() {
+	jumpClientsLock.Lock()
+	defer jumpClientsLock.Unlock()
+	for key, jc := range jumpClients {
+		if jc.client != nil {
+			jc.client.Close()
+		}
+		delete(jumpClients, key)
+	}
+	jumpClientsByConn = make(map[*ssh.Client]*jumpClient)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetJumpClientRetriesExpiredFailure(t *testing.T) {
+	Config.HostKeyChecking = "off"
+	Config.AuthType = 1
+	Config.SSHDefaultTimeout = 1
+	defer closeJumpClients()
+
+	// nothing listens on port 1, the dial is refused at once
+	hop := SSH{Server: "127.0.0.1", Port: "1", User: "root", Password: "secret"}
+	_, err := getJumpClient([]SSH{hop}, 1)
+	if err == nil {
+		t.Fatalf("getJumpClient through a refused bastion succeeded")
+	}
+	jc := jumpClients[">root@127.0.0.1:1"]
+	first := jc.dialed
+
+	// the same attempt right after the failure shares it without dialing
+	getJumpClient([]SSH{hop}, 1)
+	if !jc.dialed.Equal(first) {
+		t.Errorf("the bastion was dialed again within jumpErrorTTL")
+	}
+
+	// a later batch on the same attempt dials the bastion again once the failure expired
+	jc.dialed = time.Now().Add(-2 * jumpErrorTTL)
+	getJumpClient([]SSH{hop}, 1)
+	if !jc.dialed.After(first) {
+		t.Errorf("the expired bastion failure was reused instead of dialing again")
+	}
+
+	// a later attempt always dials again
+	first = jc.dialed
+	getJumpClient([]SSH{hop}, 2)
+	if !jc.dialed.After(first) {
+		t.Errorf("the bastion failure was reused by a later attempt")
+	}
+}
+
+// testBastion is an ssh server accepting the password secret ; dropConnections closes its connections
+// as a bastion restart does, the listener staying up
+type testBastion struct {
+	address string
+	lock    sync.Mutex
+	conns   []*ssh.ServerConn
+	accepts int
+}
+
+func startTestBastion(t *testing.T) *testBastion {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, fmt.Errorf("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	bastion := &testBastion{address: listener.Addr().String()}
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+			if err != nil {
+				netConn.Close()
+				continue
+			}
+			bastion.lock.Lock()
+			bastion.conns = append(bastion.conns, conn)
+			bastion.accepts++
+			bastion.lock.Unlock()
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				for channel := range chans {
+					channel.Reject(ssh.Prohibited, "no forwarding in the test bastion")
+				}
+			}()
+		}
+	}()
+	return bastion
+}
+
+func (bastion *testBastion) dropConnections() {
+	bastion.lock.Lock()
+	defer bastion.lock.Unlock()
+	for _, conn := range bastion.conns {
+		conn.Close()
+	}
+	bastion.conns = nil
+}
+
+func (bastion *testBastion) getAccepts() int {
+	bastion.lock.Lock()
+	defer bastion.lock.Unlock()
+	return bastion.accepts
+}
+
+func TestDialDropsDeadJumpClient(t *testing.T) {
+	Config.HostKeyChecking = "off"
+	Config.AuthType = 1
+	Config.SSHDefaultTimeout = 5
+	defer closeJumpClients()
+
+	bastion := startTestBastion(t)
+	target := SSH{Server: "10.0.0.1", Port: "22", User: "root", Password: "secret", Jump: "root@" + bastion.address}
+	targetConfig := &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	hops, err := getJumpHosts(target, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := getJumpClient(hops, 1)
+	if err != nil {
+		t.Fatalf("dialing the bastion failed: %v", err)
+	}
+
+	// the bastion drops its connections ; the next dial goes through a new bastion connection
+	bastion.dropConnections()
+	if _, err := target.dial(targetConfig); err == nil {
+		t.Fatalf("the dial through the test bastion succeeded")
+	}
+	second, err := getJumpClient(hops, 1)
+	if err != nil {
+		t.Fatalf("dialing the bastion again failed: %v", err)
+	}
+	if second == first || bastion.getAccepts() != 2 {
+		t.Errorf("the dead bastion connection was reused, %v connections accepted", bastion.getAccepts())
+	}
+
+	// a bastion which only refused to reach the target is kept for the other hosts
+	if _, err := target.dial(targetConfig); err == nil {
+		t.Fatalf("the dial through the test bastion succeeded")
+	}
+	if third, err := getJumpClient(hops, 1); err != nil || third != second || bastion.getAccepts() != 2 {
+		t.Errorf("a live bastion connection was dropped after a target failure, %v connections accepted", bastion.getAccepts())
+	}
+}
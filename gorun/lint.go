@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lint issues severities ; only the errors make gorun lint fail
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue pre-defined struct ; a problem of a hosts or commands file, at a line of the file when it is known
+// ------------------------------------
+type LintIssue struct {
+	File     string
+	Line     int
+	Severity string
+	Message  string
+}
+
+// lintDefinition pre-defined struct ; a host or a command and where it is defined, for the duplicates checks
+// ------------------------------------
+type lintDefinition struct {
+	Name string
+	File string
+	Line int
+}
+
+// Linter pre-defined struct ; the issues found in the files linted so far, with their hosts and commands
+// ------------------------------------
+type Linter struct {
+	Issues      []LintIssue
+	hosts       []lintDefinition
+	commands    []lintDefinition
+	keyErr      error
+	keyReported bool
+}
+
+// hostsFileKeys are the top level keys of a hosts file
+var hostsFileKeys = map[string]reflect.Type{
+	"nodes":    reflect.TypeOf([]SSH{}),
+	"bastions": reflect.TypeOf([]SSH{}),
+	"defaults": reflect.TypeOf(map[string]string{}),
+	"groups":   reflect.TypeOf(map[string][]string{}),
+	"vars":     reflect.TypeOf(map[string]string{}),
+}
+
+// commandsFileKeys are the top level keys of a commands file
+var commandsFileKeys = map[string]reflect.Type{
+	"commands": reflect.TypeOf([]Command{}),
+}
+
+// yamlLinePattern finds the line of a YAML syntax error
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// runLint checks the hosts and commands files selected by the configuration and prints the issues as
+// file:line: severity: message ; it returns false when an error was found
+func runLint() bool {
+	var linter Linter
+	_, linter.keyErr = os.Stat(KeyFile)
+
+	hostsFiles, inventories, missing, err := getHostsFiles(Config.HostsFolder, Config.HostsFile)
+	if err != nil {
+		linter.add(Config.HostsFolder, 0, LintError, "%v", strings.TrimPrefix(strings.TrimSpace(err.Error()), "error: "))
+	} else if len(hostsFiles) == 0 && len(missing) == 0 && len(inventories) == 0 && len(Config.InventoryURLs) == 0 && !Config.SSHConfigInventory {
+		// without hosts, every run would match no host
+		linter.add(Config.HostsFolder, 0, LintError, "no hosts file found in folder %v", Config.HostsFolder)
+	}
+	for _, pattern := range missing {
+		linter.add(Config.HostsFolder, 0, LintError, "no hosts file matches '%v' of HostsFile from %v", pattern, ConfigSources["HostsFile"])
+	}
+	for _, file := range hostsFiles {
+		linter.lintHostsFile(file)
+	}
+	commandsFiles, err := getCommandsFiles(Config.CommandsFolder)
+	if err != nil {
+		linter.add(Config.CommandsFolder, 0, LintError, "%v", strings.TrimPrefix(strings.TrimSpace(err.Error()), "error: "))
+	}
+	for _, file := range commandsFiles {
+		linter.lintCommandsFile(file)
+	}
+	linter.lintDuplicateHosts()
+	linter.lintDuplicateCommands()
+
+	errorsCount := 0
+	sort.SliceStable(linter.Issues, func(i, j int) bool {
+		if linter.Issues[i].File != linter.Issues[j].File {
+			return linter.Issues[i].File < linter.Issues[j].File
+		}
+		return linter.Issues[i].Line < linter.Issues[j].Line
+	})
+	for _, issue := range linter.Issues {
+		location := issue.File
+		if issue.Line > 0 {
+			location = fmt.Sprintf("%v:%v", issue.File, issue.Line)
+		}
+		fmt.Printf("%v: %v: %v\n", location, issue.Severity, issue.Message)
+		if issue.Severity == LintError {
+			errorsCount++
+		}
+	}
+	fmt.Printf("%v errors, %v warnings in %v hosts files and %v commands files\n",
+		errorsCount, len(linter.Issues)-errorsCount, len(hostsFiles), len(commandsFiles))
+	return errorsCount == 0
+}
+
+func (linter *Linter) add(file string, line int, severity string, format string, args ...interface{}) {
+	linter.Issues = append(linter.Issues, LintIssue{File: file, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// readLintYaml parses the file keeping the lines of its keys ; it returns nil for a syntax error or an empty file
+func (linter *Linter) readLintYaml(file string) *yaml.Node {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		linter.add(file, 0, LintError, "%v", err)
+		return nil
+	}
+	var root yaml.Node
+	err = yaml.Unmarshal(content, &root)
+	if err != nil {
+		line := 0
+		message := err.Error()
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			line, _ = strconv.Atoi(match[1])
+			message = strings.TrimPrefix(message, match[0])
+		}
+		linter.add(file, line, LintError, "invalid YAML: %v", strings.TrimPrefix(message, "yaml: "))
+		return nil
+	}
+	if len(root.Content) == 0 {
+		linter.add(file, 0, LintWarning, "empty file")
+		return nil
+	}
+	return root.Content[0]
+}
+
+// lintHostsFile checks a hosts file ; the Ansible inventories are read as gorun reads them, without lines
+func (linter *Linter) lintHostsFile(file string) {
+	if filepath.Ext(file) == ".ini" {
+		nodes, err := readAnsibleIniFile(file)
+		if err != nil {
+			linter.add(file, 0, LintError, "%v", strings.TrimPrefix(err.Error(), "error: "))
+		}
+		linter.lintAnsibleHosts(file, nodes)
+		return
+	}
+
+	document := linter.readLintYaml(file)
+	if document == nil {
+		return
+	}
+	if document.Kind == yaml.MappingNode && getYamlValue(document, "all") != nil && getYamlValue(document, "nodes") == nil {
+		nodes, err := readHostsYamlFile(file)
+		if err != nil {
+			linter.add(file, 0, LintError, "%v", err)
+		}
+		linter.lintAnsibleHosts(file, nodes)
+		return
+	}
+
+	top := linter.lintMapping(file, document, hostsFileKeys, "the hosts file")
+	defaultPort := "22"
+	if defaults := top["defaults"]; defaults != nil {
+		values := linter.lintMapping(file, defaults, getYamlKeys(reflect.TypeOf(SSHDefaults{})), "the defaults")
+		linter.lintCredentials(file, values)
+		if port := values["port"]; port != nil {
+			linter.lintPort(file, port)
+			defaultPort = port.Value
+		}
+	}
+	if groups := top["groups"]; groups != nil && groups.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(groups.Content); i += 2 {
+			if members := groups.Content[i+1]; members.Kind != yaml.SequenceNode {
+				linter.add(file, members.Line, LintError, "the group '%v' must be a list of host expressions", groups.Content[i].Value)
+			}
+		}
+	}
+
+	for _, section := range []string{"nodes", "bastions"} {
+		items := top[section]
+		if items == nil || items.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range items.Content {
+			values := linter.lintMapping(file, item, getYamlKeys(reflect.TypeOf(SSH{})), "the "+strings.TrimSuffix(section, "s"))
+			linter.lintCredentials(file, values)
+			server := values["server"]
+			if server == nil || strings.TrimSpace(server.Value) == "" {
+				linter.add(file, item.Line, LintError, "the %v has no server", strings.TrimSuffix(section, "s"))
+				continue
+			}
+			port := defaultPort
+			if values["port"] != nil {
+				linter.lintPort(file, values["port"])
+				port = values["port"].Value
+			}
+			if section == "nodes" {
+				linter.hosts = append(linter.hosts, lintDefinition{Name: server.Value + ":" + port, File: file, Line: server.Line})
+			}
+		}
+	}
+}
+
+// lintAnsibleHosts checks the ports of the Ansible inventory hosts and keeps them for the duplicates check
+func (linter *Linter) lintAnsibleHosts(file string, nodes Nodes) {
+	for _, node := range nodes {
+		port := node.Client.Port
+		if port == "" {
+			port = node.Client.Defaults.Port
+		}
+		if port == "" {
+			port = "22"
+		}
+		if !isValidPort(port) {
+			linter.add(file, 0, LintError, "invalid port '%v' of the host %v", port, node.Client.Server)
+		}
+		linter.hosts = append(linter.hosts, lintDefinition{Name: node.Client.Server + ":" + port, File: file})
+	}
+}
+
+// lintCommandsFile checks the commands of a commands file, their parameters and their templates
+func (linter *Linter) lintCommandsFile(file string) {
+	document := linter.readLintYaml(file)
+	if document == nil {
+		return
+	}
+	top := linter.lintMapping(file, document, commandsFileKeys, "the commands file")
+	items := top["commands"]
+	if items == nil || items.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range items.Content {
+		values := linter.lintMapping(file, item, getYamlKeys(reflect.TypeOf(Command{})), "the command")
+		name := values["name"]
+		if name == nil || strings.TrimSpace(name.Value) == "" {
+			linter.add(file, item.Line, LintError, "the command has no name labels")
+		} else {
+			linter.commands = append(linter.commands, lintDefinition{Name: name.Value, File: file, Line: name.Line})
+			for _, label := range strings.Fields(name.Value) {
+				if strings.Contains(label, "=") || strings.HasPrefix(label, "-") {
+					linter.add(file, name.Line, LintError, "the label '%v' of '%v' cannot be typed, the labels cannot contain = or start with -",
+						label, name.Value)
+				}
+			}
+		}
+
+		command := values["command"]
+		if command == nil || strings.TrimSpace(command.Value) == "" {
+			linter.add(file, item.Line, LintError, "the command '%v' is empty", getYamlString(name))
+		} else {
+			linter.lintTemplate(file, command, "command")
+		}
+		if args := values["args"]; args != nil {
+			linter.lintTemplate(file, args, "args")
+		}
+		if params := values["params"]; params != nil && params.Kind == yaml.SequenceNode {
+			linter.lintParams(file, params, getYamlString(name))
+		}
+	}
+}
+
+// lintParams checks the parameters names, types, regexes and defaults of a command
+func (linter *Linter) lintParams(file string, params *yaml.Node, commandName string) {
+	names := make(map[string]bool)
+	for _, item := range params.Content {
+		values := linter.lintMapping(file, item, getYamlKeys(reflect.TypeOf(CommandParam{})), "the parameter")
+		param := CommandParam{Name: getYamlString(values["name"]), Type: getYamlString(values["type"]),
+			Default: getYamlString(values["default"]), Regex: getYamlString(values["regex"])}
+		if enum := values["enum"]; enum != nil {
+			for _, value := range enum.Content {
+				param.Enum = append(param.Enum, value.Value)
+			}
+		}
+		if param.Name == "" {
+			linter.add(file, item.Line, LintError, "a parameter of '%v' has no name", commandName)
+			continue
+		}
+		if names[param.Name] {
+			linter.add(file, values["name"].Line, LintError, "duplicate parameter '%v' of '%v'", param.Name, commandName)
+		}
+		names[param.Name] = true
+
+		// an empty value checks the type and the regex, the validation of the value itself being expected to fail
+		value, line := param.Default, item.Line
+		if values["default"] != nil {
+			line = values["default"].Line
+		}
+		_, err := param.validate(value)
+		if value != "" && err != nil {
+			linter.add(file, line, LintError, "the default of %v", strings.TrimPrefix(err.Error(), "error: "))
+		} else if err != nil && (strings.Contains(err.Error(), "unsupported type") || strings.Contains(err.Error(), "invalid regex")) {
+			linter.add(file, line, LintError, "%v", strings.TrimPrefix(err.Error(), "error: "))
+		}
+	}
+}
+
+// lintMapping checks the keys of a mapping and the kinds of their values ; it returns the values by lowercase key,
+// as the configuration reader matches the keys ignoring the case
+func (linter *Linter) lintMapping(file string, node *yaml.Node, keys map[string]reflect.Type, what string) map[string]*yaml.Node {
+	values := make(map[string]*yaml.Node)
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		if node.Tag != "!!null" {
+			linter.add(file, node.Line, LintError, "%v must be a mapping", what)
+		}
+		return values
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		name := strings.ToLower(key.Value)
+		keyType, ok := keys[name]
+		if !ok {
+			linter.add(file, key.Line, LintError, "unknown key '%v' in %v%v", key.Value, what, getKeySuggestion(name, keys))
+			continue
+		}
+		if _, exists := values[name]; exists {
+			linter.add(file, key.Line, LintError, "duplicate key '%v' in %v", key.Value, what)
+		}
+		values[name] = value
+		if kind := getYamlKindError(value, keyType); kind != "" {
+			linter.add(file, value.Line, LintError, "the %v key of %v must be %v", key.Value, what, kind)
+		}
+	}
+	return values
+}
+
+// lintCredentials checks the passwords and passphrases decrypt with the current key file
+func (linter *Linter) lintCredentials(file string, values map[string]*yaml.Node) {
+	for _, key := range []string{"password", "passphrase"} {
+		value := values[key]
+		if value == nil || value.Value == "" {
+			continue
+		}
+		if linter.keyErr != nil {
+			if !linter.keyReported {
+				linter.add(file, value.Line, LintError, "cannot check the %v, the key file cannot be read: %v", key, linter.keyErr)
+				linter.keyReported = true
+			}
+			continue
+		}
+		decoded, err := decrypt(KeyFile, value.Value)
+		if err != nil {
+			linter.add(file, value.Line, LintError, "the %v cannot be decrypted: %v", key, strings.TrimPrefix(err.Error(), "error: "))
+		} else if !isPrintable(decoded) {
+			linter.add(file, value.Line, LintError, "the %v does not decrypt with the key file %v, it was encrypted with another key",
+				key, KeyFile)
+		}
+	}
+}
+
+// lintPort checks the port is a number between 1 and 65535
+func (linter *Linter) lintPort(file string, port *yaml.Node) {
+	if !isValidPort(port.Value) {
+		linter.add(file, port.Line, LintError, "invalid port '%v'", port.Value)
+	}
+}
+
+// lintTemplate checks the command or its args parse as a Go template
+func (linter *Linter) lintTemplate(file string, node *yaml.Node, key string) {
+	if !strings.Contains(node.Value, "{{") {
+		return
+	}
+	_, err := template.New(key).Option("missingkey=error").Parse(node.Value)
+	if err != nil {
+		linter.add(file, node.Line, LintError, "invalid %v template: %v", key, strings.TrimPrefix(err.Error(), "template: "))
+	}
+}
+
+// lintDuplicateHosts reports the server:port defined twice ; in the same file it is an error, across the files
+// a warning as a host may belong to several hosts files
+func (linter *Linter) lintDuplicateHosts() {
+	first := make(map[string]lintDefinition)
+	for _, host := range linter.hosts {
+		previous, exists := first[host.Name]
+		if !exists {
+			first[host.Name] = host
+			continue
+		}
+		if previous.File == host.File {
+			linter.add(host.File, host.Line, LintError, "duplicate host %v, also defined at %v", host.Name, getLintLocation(previous))
+		} else {
+			linter.add(host.File, host.Line, LintWarning, "the host %v is also defined at %v", host.Name, getLintLocation(previous))
+		}
+	}
+}
+
+// lintDuplicateCommands reports the commands with the same labels, in any order ; the last one read shadows the others
+func (linter *Linter) lintDuplicateCommands() {
+	first := make(map[string]lintDefinition)
+	for _, command := range linter.commands {
+		labels := strings.Fields(command.Name)
+		sort.Strings(labels)
+		key := strings.Join(labels, " ")
+		previous, exists := first[key]
+		if !exists {
+			first[key] = command
+			continue
+		}
+		if strings.Join(strings.Fields(previous.Name), " ") == strings.Join(strings.Fields(command.Name), " ") {
+			linter.add(command.File, command.Line, LintError, "duplicate command labels '%v', also defined at %v ; only this one can run",
+				command.Name, getLintLocation(previous))
+		} else {
+			linter.add(command.File, command.Line, LintError, "the command '%v' shadows '%v' defined at %v, the labels match in any order",
+				command.Name, previous.Name, getLintLocation(previous))
+		}
+	}
+}
+
+func getLintLocation(definition lintDefinition) string {
+	if definition.Line > 0 {
+		return fmt.Sprintf("%v:%v", definition.File, definition.Line)
+	}
+	return definition.File
+}
+
+// getYamlKeys returns the yaml keys of a struct with their types
+func getYamlKeys(structType reflect.Type) map[string]reflect.Type {
+	keys := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if name := field.Tag.Get("yaml"); name != "" && name != "-" {
+			keys[name] = field.Type
+		}
+	}
+	return keys
+}
+
+// getYamlValue returns the value of a key of a mapping, ignoring the case
+func getYamlValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func getYamlString(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+// getYamlKindError describes the kind a value should have, empty if it has it ; the scalars are read as the
+// configuration reader does, a bool being true, false, 1 or 0 and a string being a list of one string
+func getYamlKindError(node *yaml.Node, valueType reflect.Type) string {
+	if node.Tag == "!!null" {
+		return ""
+	}
+	if valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	switch valueType.Kind() {
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			return "a string"
+		}
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(node.Value); node.Kind != yaml.ScalarNode || err != nil {
+			return "a bool"
+		}
+	case reflect.Int:
+		if _, err := strconv.Atoi(node.Value); node.Kind != yaml.ScalarNode || err != nil {
+			return "an int"
+		}
+	case reflect.Slice:
+		// a single string is read as a list of one string
+		if node.Kind == yaml.ScalarNode && valueType.Elem().Kind() == reflect.String {
+			return ""
+		}
+		if node.Kind != yaml.SequenceNode {
+			return "a list"
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return "a mapping"
+		}
+	}
+	return ""
+}
+
+// getKeySuggestion returns the known key the unknown one is probably a typo of
+func getKeySuggestion(key string, keys map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for name := range keys {
+		if distance := getEditDistance(key, name); distance < bestDistance || (distance == bestDistance && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%v'", best)
+}
+
+func isValidPort(port string) bool {
+	number, err := strconv.Atoi(port)
+	return err == nil && number > 0 && number <= 65535
+}
+
+// isPrintable tells if the decrypted text is a password ; the wrong key gives random bytes
+func isPrintable(text string) bool {
+	if !utf8.ValidString(text) {
+		return false
+	}
+	for _, c := range text {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunLintHostsFolder(t *testing.T) {
+	folder := t.TempDir()
+	empty := filepath.Join(folder, "empty")
+	hosts := filepath.Join(folder, "hosts")
+	for _, dir := range []string{empty, hosts} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(hosts, "web.yaml"), []byte("nodes:\n  - server: \"web1\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config
+	defer func() { Config = config }()
+	Config.HostsFile = "*.yaml"
+	Config.CommandsFolder = empty
+	Config.InventoryURLs = nil
+	Config.SSHConfigInventory = false
+
+	tests := []struct {
+		folder string
+		passes bool
+	}{
+		{filepath.Join(folder, "missing"), false},
+		{empty, false},
+		{hosts, true},
+	}
+	for _, test := range tests {
+		Config.HostsFolder = test.folder
+		if passes := runLint(); passes != test.passes {
+			t.Errorf("runLint with the hosts folder %v passed %v, expected %v", test.folder, passes, test.passes)
+		}
+	}
+}
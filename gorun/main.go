@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -14,6 +15,27 @@ type cliArgs struct {
 	hostPattern string
 	command     string
 	args        string
+	params      []string
+	options     map[string]string
+}
+
+// cliValueOptions lists the options followed by a value
+var cliValueOptions = map[string]bool{
+	"--config": true,
+	"--forks":  true,
+	"--serial": true,
+	"--output": true,
+	"--watch":  true,
+}
+
+// cliCommands lists the pseudo-commands given in place of the command
+var cliCommands = []string{"--list", "--commands", "--exec", "--ssh", "--repl", "--push", "--pull", "--export"}
+
+// cliBoolOptions lists the options used as switches
+var cliBoolOptions = map[string]bool{
+	"--abort-on-fail": true,
+	"--collapse":      true,
+	"--sudo":          true,
 }
 
 func readStdinPipe() string {
@@ -50,25 +72,158 @@ func getArgs() (cliArgs, error) {
 	var cli cliArgs
 	scriptPathSlice := strings.Split(os.Args[0], "/")
 	cli.scriptName = scriptPathSlice[len(scriptPathSlice)-1]
-	args := os.Args[1:]
-	if len(args) < 2 {
+	args, options, err := splitOptions(os.Args[1:])
+	if err != nil {
+		return cli, err
+	}
+	cli.options = options
+	if len(args) > 0 {
+		cli.hostPattern = args[0]
+	}
+	if len(args) < 2 && cli.hostPattern != "lint" {
 		return cli, errors.New("error: insufficient arguments")
 	}
-	cli.hostPattern = args[0]
-	cli.command = args[1]
+	if len(args) > 1 {
+		cli.command = args[1]
+	}
 	cli.args = ""
 	if len(args) > 2 {
 		cli.args = strings.Join(args[2:], " ")
+		cli.params = args[2:]
 	}
 
 	return cli, nil
 }
 
+// splitOptions separates the known --options from the positional arguments.
+// Everything after a standalone "--" or after the command labels or --exec is kept as positional,
+// the options of the remote command such as df --output=source going to it.
+func splitOptions(args []string) ([]string, map[string]string, error) {
+	var positional []string
+	options := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if isRemoteCommandStart(positional) {
+			positional = append(positional, args[i:]...)
+			break
+		}
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if cliValueOptions[name] {
+			if !hasValue {
+				if i+1 >= len(args) {
+					return positional, options, fmt.Errorf("error: option '%v' requires a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			options[name] = value
+		} else if cliBoolOptions[name] {
+			if !hasValue {
+				value = "true"
+			}
+			options[name] = value
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return positional, options, nil
+}
+
+// isRemoteCommandStart tells if the positional arguments end with the command labels or --exec, the arguments
+// after them belonging to the remote command ; the other pseudo-commands and config show still take options
+func isRemoteCommandStart(positional []string) bool {
+	if len(positional) != 2 || positional[0] == "config" {
+		return false
+	}
+	return positional[1] == "--exec" || !containsLabel(cliCommands, positional[1])
+}
+
+// applyOptions checks the layered Config values and applies the options which are not Config fields
+func applyOptions(options map[string]string) error {
+	if Config.Forks < 0 {
+		return fmt.Errorf("error: invalid Forks value '%v' from %v", Config.Forks, ConfigSources["Forks"])
+	}
+	if value, ok := options["--watch"]; ok {
+		interval, err := getWatchInterval(value)
+		if err != nil {
+			return err
+		}
+		WatchInterval = interval
+	}
+	if !outputFormats[Config.Output] {
+		return fmt.Errorf("error: unsupported output format '%v'", Config.Output)
+	}
+	if _, err := getBatchSize(1, Config.Serial); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getTransferCommand builds the command used to push or pull files ; folders are copied recursively,
+// pulled files are written in a sub folder per host
+func getTransferCommand(option string, params []string) (Command, error) {
+	var command Command
+	var transfer Transfer
+	if option == "--push" {
+		if len(params) < 2 || len(params) > 3 {
+			return command, errors.New("error: --push requires <local> <remote> [<mode>]")
+		}
+		if _, err := os.Stat(params[0]); err != nil {
+			return command, err
+		}
+		transfer = Transfer{Direction: TransferPush, Source: params[0], Destination: params[1]}
+		if len(params) == 3 {
+			if _, err := strconv.ParseUint(params[2], 8, 32); err != nil || len(params[2]) != 4 {
+				return command, fmt.Errorf("error: invalid file mode '%v', expected an octal mode such as 0644", params[2])
+			}
+			transfer.Mode = params[2]
+		}
+	} else {
+		if len(params) != 2 {
+			return command, errors.New("error: --pull requires <remote> <localdir>")
+		}
+		transfer = Transfer{Direction: TransferPull, Source: params[0], Destination: params[1]}
+	}
+	command.Name = fmt.Sprintf("%v %v %v", transfer.Direction, transfer.Source, transfer.Destination)
+	command.Command = command.Name
+	command.Transfer = &transfer
+
+	return command, nil
+}
+
+// listCommands prints the commands files commands with their parameters
+func listCommands(commands []Command) {
+	var lines []string
+	lines = append(lines, "LABELS\tCOMMAND\tDESCRIPTION")
+	for _, command := range commands {
+		line := fmt.Sprintf("%v\t%.35v\t%v", command.Name, strings.ReplaceAll(command.Command, "\n", " "), command.Description)
+		lines = append(lines, line)
+		for _, param := range command.Params {
+			description := param.Description
+			if param.Default != "" {
+				description = fmt.Sprintf("%v (default: %v)", description, param.Default)
+			}
+			lines = append(lines, fmt.Sprintf("\t  %v\t%v", param.getSignature(), strings.TrimSpace(description)))
+		}
+	}
+	printTabbedTable(lines)
+}
+
+// listMatchedHosts prints the selected hosts with their group, their tags and the parts of the host expression they matched
 func listMatchedHosts(nodes Nodes) {
 	var lines []string
-	lines = append(lines, "NODES")
+	lines = append(lines, "NODES\tGROUP\tTAGS\tMATCHED BY")
 	for _, node := range nodes {
-		line := fmt.Sprintf("%v@%v:%v", node.Client.User, node.Client.Server, node.Client.Port)
+		matched := strings.Join(node.Matched, " & ")
+		if matched == "" {
+			matched = "*"
+		}
+		line := fmt.Sprintf("%v@%v:%v\t%v\t%v\t%v", node.Client.User, node.Client.Server, node.Client.Port,
+			node.Group, strings.Join(node.Client.Tags, ","), matched)
 		lines = append(lines, line)
 	}
 	printTabbedTable(lines)
@@ -76,32 +231,128 @@ func listMatchedHosts(nodes Nodes) {
 
 func showHelp(scriptName string) {
 	help := `Usage :
-	scriptName <hosts> <command>
+	scriptName [<options>] <hosts> [<options>] <command>
 	scriptName <hosts> --list
+	scriptName <hosts> --commands [<filter>]
+	scriptName <hosts> <labels> [name=value ...] [<args>]
+	scriptName <hosts> --exec <command> [<args>]
+	scriptName <host> --ssh
+	scriptName <hosts> --repl
+	scriptName <hosts> --push <local> <remote> [<mode>]
+	scriptName <hosts> --pull <remote> <localdir>
+	scriptName <hosts> --export ansible|ansible-yaml
+	scriptName completion bash|zsh|fish
+	scriptName config show
+	scriptName lint
+
+Hosts :
+	web1,web2           union of the hosts matching the server regexes
+	@group              the hosts of a hosts file or of a group declared in the groups of the hosts files
+	role=db, tag=gpu    the hosts with the role=db tag, the hosts with the gpu tag
+	@prod&role=db       intersection
+	@prod,!web3         exclusion of the hosts matching web3 from the hosts before it
+	(@a,@b)&!role=db    parentheses and negation
+	web(1|2),db[0-9]{1,3}  the server regexes keep the operators inside their (...), [...] and {...} ;
+	                    a regex starting with ( is prefixed with ~, as in ~(web|db)1
+	the .ini files and the YAML files with an all key of the hosts folder are read as Ansible inventories,
+	with their group_vars and host_vars ; their groups are selected as @group
+
+Commands :
+	the commands files command and args are Go templates rendered per host with the host vars and the built-ins
+	{{.Server}}, {{.Port}}, {{.User}}, {{.Group}} and {{.Index}} ; an undefined var stops the run and
+	{{"{{"}} writes a literal {{ ; the one time commands and --exec run as typed, {{.Names}} included
+	the commands files commands take their params as name=value, validated and inserted shell quoted
+	the labels select a commands files command by its full labels in any order ; their prefixes (cpu us) and
+	misspellings list the commands they may mean, a command line matching none runs as a one time command
+	lint checks the hosts and commands files, printing file:line: error: message, and exits with 1 on errors
+
+Options :
+	the options go before the command labels or --exec, the arguments after them are passed to the command as
+	typed ; scriptName web --output json df --output=source runs df --output=source with the json output
+	--forks <n>         maximum number of hosts running in parallel
+	--serial <n|n%>     run the hosts in rolling batches of n hosts or n% of the hosts
+	--abort-on-fail     stop the rolling run after a batch with failed hosts
+	--output <format>   output format: text, json, ndjson, csv or junit
+	--collapse          print each distinct output once, with the list of hosts producing it
+	--watch <interval>  re-run the command every interval (5, 2s, 1m) and redraw its output table in place
+	--sudo              run the command through sudo, answering its prompt with the host password
+	--config <file>     configuration file layered over /etc/gorun, ~/.gorun and the current folder config.yaml
+	--env <profile>     use a profile of the configuration files, with its hosts files, key file, timeouts and
+	                    safety rules ; also set by GORUN_ENV
+	--max-hosts <n>     refuse to run on more than n hosts
+	--confirm           ask to type the profile name before running
+	--<key> <value>     any config.yaml key, such as --hosts-folder <folder> or GORUN_HOSTS_FOLDER=<folder>
 	`
 	help = strings.ReplaceAll(help, "scriptName", scriptName)
 	fmt.Println(help)
 }
 
 func main() {
-	Config = readConfigFile("config.yaml")
-	KeyFile = os.Getenv("HOME") + "/.gorun/.config"
+	registerConfigOptions()
+	if len(os.Args) > 1 && (os.Args[1] == "completion" || os.Args[1] == completeCommand) {
+		scriptPathSlice := strings.Split(os.Args[0], "/")
+		runCompletion(scriptPathSlice[len(scriptPathSlice)-1], os.Args[1:])
+		return
+	}
+
+	cli, cliErr := getArgs()
+	var err error
+	Config, err = loadConfig(cli.options)
+	if err != nil {
+		// an invalid configuration or an unknown --env profile fails the run, as the lint errors do
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if cli.hostPattern == "config" {
+		if cli.command != "show" {
+			fmt.Fprintf(os.Stderr, "error: usage: %v config show\n", cli.scriptName)
+			os.Exit(1)
+		}
+		showConfig(Config)
+		return
+	}
+	KeyFile = expandHome(Config.KeyFile)
+	if cli.hostPattern == "lint" {
+		if !runLint() {
+			os.Exit(1)
+		}
+		return
+	}
 	pipe := readStdinPipe()
+	defer Pool.Close()
+
+	if Config.SSHConfigFile != "" {
+		UserSSHConfig, err = readSSHConfigFile(Config.SSHConfigFile)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "warning: cannot read %v: %v\n", Config.SSHConfigFile, err)
+		}
+	}
 
 	hosts, err := readAllHostsFilesInFolder(Config.HostsFolder, Config.HostsFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		return
+		os.Exit(1)
 	}
 	for i := 0; i < len(hosts); i++ {
 		hosts[i].Client.initHosts()
 	}
 
-	cli, err := getArgs()
+	commands, err := readAllCommandsFilesInFolder(Config.CommandsFolder)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	initCommands(commands)
+
+	if cliErr != nil {
 		showHelp(cli.scriptName)
 		return
 	}
+	err = applyOptions(cli.options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
 
 	matchedHosts, err := matchHost(cli.hostPattern, hosts)
 	if err != nil {
@@ -115,12 +366,116 @@ func main() {
 		listMatchedHosts(matchedHosts)
 		break
 
+	case "--commands":
+		filter := strings.Join(cli.params, " ")
+		filtered := filterCommands(commands, filter)
+		if len(filtered) == 0 {
+			fmt.Fprintf(os.Stderr, "error: no command matches '%v' in the commands files in '%v'\n", filter, Config.CommandsFolder)
+			return
+		}
+		listCommands(filtered)
+		fmt.Println()
+		break
+
+	case "--exec":
+		if len(cli.params) == 0 {
+			fmt.Fprintf(os.Stderr, "error: --exec requires a command\n")
+			showHelp(cli.scriptName)
+			return
+		}
+		var execCommand Command
+		execCommand.Command = cli.params[0]
+		execCommand.Args = strings.Join(cli.params[1:], " ")
+		execCommand.Name = cli.params[0]
+		execCommand.Pipe = pipe
+		if err := checkSafetyRules(matchedHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		runOrWatchCommandOnHosts(execCommand, matchedHosts)
+		break
+
+	case "--repl":
+		if err := checkSafetyRules(matchedHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		runRepl(hosts, matchedHosts)
+		break
+
+	case "--export":
+		format := ExportAnsible
+		if len(cli.params) > 0 {
+			format = cli.params[0]
+		}
+		err = exportAnsibleInventory(os.Stdout, format, matchedHosts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		break
+
+	case "--ssh":
+		if err := checkSafetyRules(matchedHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		err = openShellOnHosts(matchedHosts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		break
+
+	case "--push", "--pull":
+		transferCommand, err := getTransferCommand(cli.command, cli.params)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			showHelp(cli.scriptName)
+			return
+		}
+		if err := checkSafetyRules(matchedHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		runCommandOnHosts(transferCommand, matchedHosts)
+		break
+
 	default:
 		var execCommand Command
-		execCommand.Command = cli.command
-		execCommand.Args = cli.args
-		execCommand.Name = cli.command
+		match := resolveCommand(append([]string{cli.command}, cli.params...), commands)
+		if len(match.Commands) > 0 && !match.isRunnable() {
+			fmt.Fprintf(os.Stderr, "error: the labels '%v' are not the labels of a command, did you mean :\n\n", match.Labels)
+			listCommands(match.Commands)
+			fmt.Println()
+			fmt.Printf("For running '%v' as a one time command, you can use :\n", match.Labels)
+			fmt.Printf("%v '%v' --exec '%v'\n\n", cli.scriptName, cli.hostPattern, match.Labels)
+			return
+		}
+		if len(match.Commands) == 1 {
+			execCommand = match.Commands[0]
+			err = applyParams(&execCommand, match.Params)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return
+			}
+		} else {
+			execCommand.Command = cli.command
+			execCommand.Args = cli.args
+			execCommand.Name = cli.command
+		}
 		execCommand.Pipe = pipe
-		runCommandOnHosts(execCommand, matchedHosts)
+		if err := checkSafetyRules(matchedHosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		runOrWatchCommandOnHosts(execCommand, matchedHosts)
+	}
+}
+
+// runOrWatchCommandOnHosts runs the command once, or every WatchInterval with --watch
+func runOrWatchCommandOnHosts(command Command, sshClients Nodes) {
+	if WatchInterval > 0 {
+		watchCommandOnHosts(command, sshClients)
+		return
 	}
+	runCommandOnHosts(command, sshClients)
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitOptions(t *testing.T) {
+	registerConfigOptions()
+	tests := []struct {
+		args       []string
+		positional []string
+		options    map[string]string
+		fails      bool
+	}{
+		{[]string{"web", "df", "--output=source"}, []string{"web", "df", "--output=source"}, map[string]string{}, false},
+		{[]string{"--output", "json", "web", "df", "-h"}, []string{"web", "df", "-h"}, map[string]string{"--output": "json"}, false},
+		{[]string{"web", "--forks=2", "--collapse", "cpu", "usage", "--forks", "3"},
+			[]string{"web", "cpu", "usage", "--forks", "3"}, map[string]string{"--forks": "2", "--collapse": "true"}, false},
+		{[]string{"web", "--exec", "ls", "--sudo"}, []string{"web", "--exec", "ls", "--sudo"}, map[string]string{}, false},
+		{[]string{"web", "--push", "a", "/tmp", "--forks", "2"}, []string{"web", "--push", "a", "/tmp"}, map[string]string{"--forks": "2"}, false},
+		{[]string{"config", "show", "--env", "prod"}, []string{"config", "show"}, map[string]string{"--env": "prod"}, false},
+		{[]string{"lint", "--hosts-folder", "hosts"}, []string{"lint"}, map[string]string{"--hosts-folder": "hosts"}, false},
+		{[]string{"web", "--", "--sudo", "x"}, []string{"web", "--sudo", "x"}, map[string]string{}, false},
+		{[]string{"web", "--forks"}, nil, nil, true},
+	}
+	for _, test := range tests {
+		positional, options, err := splitOptions(test.args)
+		if test.fails != (err != nil) {
+			t.Errorf("splitOptions(%q) error = %v, expected failure %v", test.args, err, test.fails)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		if !reflect.DeepEqual(positional, test.positional) || !reflect.DeepEqual(options, test.options) {
+			t.Errorf("splitOptions(%q) = %q %v, expected %q %v", test.args, positional, options, test.positional, test.options)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// outputFormats lists the supported values of Config.Output
+var outputFormats = map[string]bool{
+	"":       true,
+	"text":   true,
+	"json":   true,
+	"ndjson": true,
+	"csv":    true,
+	"junit":  true,
+}
+
+// HostRecord pre-defined struct ; one per host in the machine-readable outputs
+// ------------------------------------
+type HostRecord struct {
+	Type       string  `json:"type"`
+	Server     string  `json:"server"`
+	Port       string  `json:"port"`
+	User       string  `json:"user"`
+	Command    string  `json:"command"`
+	Status     string  `json:"status"`
+	ReturnCode int     `json:"rc"`
+	Signal     string  `json:"signal,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Duration   float64 `json:"duration"`
+	Attempts   int     `json:"attempts"`
+	Stdout     string  `json:"stdout"`
+	Stderr     string  `json:"stderr"`
+}
+
+// SummaryRecord pre-defined struct ; the run summary in the machine-readable outputs
+// ------------------------------------
+type SummaryRecord struct {
+	Type        string  `json:"type"`
+	Env         string  `json:"env,omitempty"`
+	Command     string  `json:"command"`
+	Duration    float64 `json:"duration"`
+	Passed      int     `json:"passed"`
+	Failed      int     `json:"failed"`
+	Unreachable int     `json:"unreachable"`
+	Skipped     int     `json:"skipped"`
+	Total       int     `json:"total"`
+}
+
+// junitTestSuite and junitTestCase map the JUnit XML schema used by the CI jobs
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func isTextOutput() bool {
+	return Config.Output == "" || Config.Output == "text"
+}
+
+func getHostRecords(sshClients Nodes, command string) []HostRecord {
+	var records []HostRecord
+	for _, node := range sshClients {
+		records = append(records, HostRecord{
+			Type:       "host",
+			Server:     node.Client.Server,
+			Port:       node.Client.Port,
+			User:       node.Client.User,
+			Command:    command,
+			Status:     getNodeStatus(node),
+			ReturnCode: node.Result.ReturnCode,
+			Signal:     node.Result.Signal,
+			Error:      node.Result.Error,
+			Duration:   node.Result.Duration.Seconds(),
+			Attempts:   node.Result.Attempts,
+			Stdout:     node.Result.Stdout,
+			Stderr:     node.Result.Stderr,
+		})
+	}
+	return records
+}
+
+func getSummaryRecord(records []HostRecord, command string, duration time.Duration) SummaryRecord {
+	summary := SummaryRecord{Type: "summary", Env: Config.Env, Command: command, Duration: duration.Seconds(), Total: len(records)}
+	for _, record := range records {
+		switch record.Status {
+		case StatusPassed:
+			summary.Passed++
+		case StatusSkipped:
+			summary.Skipped++
+		case StatusUnreachable, StatusHostKey, StatusHostUnknown, StatusAuthFailed:
+			summary.Unreachable++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// printStructuredOutput prints the run results using the machine-readable format set in Config.Output
+func printStructuredOutput(sshClients Nodes, command string, duration time.Duration) error {
+	records := getHostRecords(sshClients, command)
+	summary := getSummaryRecord(records, command, duration)
+
+	switch Config.Output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Hosts   []HostRecord  `json:"hosts"`
+			Summary SummaryRecord `json:"summary"`
+		}{records, summary})
+
+	case "ndjson":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return encoder.Encode(summary)
+
+	case "csv":
+		return printCsvOutput(records, summary)
+
+	case "junit":
+		return printJunitOutput(records, summary)
+	}
+	return fmt.Errorf("error: unsupported output format '%v'", Config.Output)
+}
+
+// printCsvOutput writes one row per host on stdout ; the summary goes to stderr to keep the csv parseable
+func printCsvOutput(records []HostRecord, summary SummaryRecord) error {
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"server", "port", "user", "command", "status", "rc", "signal", "error", "duration", "attempts", "stdout", "stderr"})
+	for _, r := range records {
+		writer.Write([]string{r.Server, r.Port, r.User, r.Command, r.Status, strconv.Itoa(r.ReturnCode), r.Signal, r.Error,
+			strconv.FormatFloat(r.Duration, 'f', 3, 64), strconv.Itoa(r.Attempts), r.Stdout, r.Stderr})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	env := ""
+	if summary.Env != "" {
+		env = fmt.Sprintf("env=%v ", summary.Env)
+	}
+	fmt.Fprintf(os.Stderr, "summary: %vcommand=%v duration=%.3fs passed=%v failed=%v unreachable=%v skipped=%v total=%v\n",
+		env, summary.Command, summary.Duration, summary.Passed, summary.Failed, summary.Unreachable, summary.Skipped, summary.Total)
+	return nil
+}
+
+// printJunitOutput writes a JUnit testsuite where every host is a testcase
+func printJunitOutput(records []HostRecord, summary SummaryRecord) error {
+	suite := junitTestSuite{
+		Name:     summary.Command,
+		Tests:    summary.Total,
+		Failures: summary.Failed,
+		Errors:   summary.Unreachable,
+		Skipped:  summary.Skipped,
+		Time:     strconv.FormatFloat(summary.Duration, 'f', 3, 64),
+	}
+	if summary.Env != "" {
+		suite.Properties = append(suite.Properties, junitProperty{Name: "env", Value: summary.Env})
+	}
+	for _, r := range records {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%v:%v", r.Server, r.Port),
+			ClassName: r.Command,
+			Time:      strconv.FormatFloat(r.Duration, 'f', 3, 64),
+			SystemOut: r.Stdout,
+			SystemErr: r.Stderr,
+		}
+		switch r.Status {
+		case StatusPassed:
+		case StatusSkipped:
+			testCase.Skipped = &junitMessage{Message: "skipped by the rolling run"}
+		case StatusUnreachable, StatusHostKey, StatusHostUnknown, StatusAuthFailed:
+			testCase.Error = &junitMessage{Message: r.Status, Text: r.Error}
+		default:
+			testCase.Failure = &junitMessage{Message: fmt.Sprintf("%v (rc: %v)", r.Status, r.ReturnCode), Text: r.Error}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	output, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v%v\n", xml.Header, string(output))
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureFile returns what run writes to the standard file, os.Stdout or os.Stderr
+func captureFile(t *testing.T, standard **os.File, run func()) string {
+	file, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *standard
+	*standard = file
+	run()
+	*standard = saved
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestPrintStructuredOutput(t *testing.T) {
+	Config.Env = "prod"
+	defer func() { Config.Output = ""; Config.Env = "" }()
+	nodes := Nodes{
+		{Client: SSH{Server: "web1", Port: "22", User: "root"}, Result: CommandResult{Stdout: "ok, \"done\"\nline 2", Status: StatusPassed}},
+		{Client: SSH{Server: "web2", Port: "22", User: "root"}, Result: CommandResult{Stderr: "<no space>", ReturnCode: 2, Status: StatusFailed}},
+		{Client: SSH{Server: "web3", Port: "2222", User: "ops"}, Result: CommandResult{ReturnCode: -1, Status: StatusUnreachable, Error: "connection refused"}},
+		{Client: SSH{Server: "web4", Port: "22", User: "root"}, Skipped: true},
+	}
+
+	tests := []struct {
+		output string
+		check  func(t *testing.T, stdout string, stderr string)
+	}{
+		{"json", func(t *testing.T, stdout string, stderr string) {
+			var result struct {
+				Hosts   []HostRecord  `json:"hosts"`
+				Summary SummaryRecord `json:"summary"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+				t.Fatalf("json: %v\n%v", err, stdout)
+			}
+			if len(result.Hosts) != 4 || result.Hosts[0].Stdout != nodes[0].Result.Stdout || result.Hosts[1].ReturnCode != 2 ||
+				result.Hosts[3].Status != StatusSkipped {
+				t.Errorf("json: hosts %+v", result.Hosts)
+			}
+			if !strings.Contains(stdout, "<no space>") {
+				t.Errorf("json: the html characters are escaped\n%v", stdout)
+			}
+			summary := result.Summary
+			if summary.Env != "prod" || summary.Passed != 1 || summary.Failed != 1 || summary.Unreachable != 1 || summary.Skipped != 1 || summary.Total != 4 {
+				t.Errorf("json: summary %+v", summary)
+			}
+		}},
+		{"ndjson", func(t *testing.T, stdout string, stderr string) {
+			lines := strings.Split(strings.TrimSpace(stdout), "\n")
+			if len(lines) != 5 {
+				t.Fatalf("ndjson: %v lines, expected 4 hosts and the summary\n%v", len(lines), stdout)
+			}
+			var host HostRecord
+			var summary SummaryRecord
+			if err := json.Unmarshal([]byte(lines[2]), &host); err != nil || host.Type != "host" || host.Port != "2222" || host.Error != "connection refused" {
+				t.Errorf("ndjson: host %+v, %v", host, err)
+			}
+			if err := json.Unmarshal([]byte(lines[4]), &summary); err != nil || summary.Type != "summary" || summary.Total != 4 {
+				t.Errorf("ndjson: summary %+v, %v", summary, err)
+			}
+		}},
+		{"csv", func(t *testing.T, stdout string, stderr string) {
+			rows, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+			if err != nil {
+				t.Fatalf("csv: %v\n%v", err, stdout)
+			}
+			columns := make(map[string]int)
+			for i, name := range rows[0] {
+				columns[name] = i
+			}
+			if len(rows) != 5 || rows[0][0] != "server" || rows[1][columns["stdout"]] != nodes[0].Result.Stdout ||
+				rows[2][columns["rc"]] != "2" || rows[4][columns["status"]] != StatusSkipped {
+				t.Errorf("csv: rows %q", rows)
+			}
+			if !strings.HasPrefix(stderr, "summary: env=prod command=df -h ") || !strings.Contains(stderr, "passed=1 failed=1 unreachable=1 skipped=1 total=4") {
+				t.Errorf("csv: summary %q", stderr)
+			}
+		}},
+		{"junit", func(t *testing.T, stdout string, stderr string) {
+			var suite junitTestSuite
+			if err := xml.Unmarshal([]byte(stdout), &suite); err != nil {
+				t.Fatalf("junit: %v\n%v", err, stdout)
+			}
+			if suite.Name != "df -h" || suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 1 ||
+				len(suite.Properties) != 1 || suite.Properties[0].Value != "prod" {
+				t.Errorf("junit: suite %+v", suite)
+			}
+			cases := suite.TestCases
+			if len(cases) != 4 || cases[0].Failure != nil || cases[0].Error != nil || cases[1].Failure == nil ||
+				cases[1].Failure.Message != "FAILED (rc: 2)" || cases[2].Error == nil || cases[2].Error.Text != "connection refused" ||
+				cases[3].Skipped == nil || cases[2].Name != "web3:2222" {
+				t.Errorf("junit: testcases %+v", cases)
+			}
+		}},
+	}
+	for _, test := range tests {
+		Config.Output = test.output
+		var err error
+		var stdout string
+		stderr := captureFile(t, &os.Stderr, func() {
+			stdout = captureFile(t, &os.Stdout, func() {
+				err = printStructuredOutput(nodes, "df -h", 1500*time.Millisecond)
+			})
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.output, err)
+			continue
+		}
+		test.check(t, stdout, stderr)
+	}
+
+	Config.Output = "yaml"
+	if err := printStructuredOutput(nodes, "df -h", time.Second); err == nil || !outputFormats["junit"] || outputFormats["yaml"] {
+		t.Errorf("the yaml output was accepted")
+	}
+}
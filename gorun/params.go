@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Command parameters types
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+)
+
+// CommandParam pre-defined struct ; a named parameter of a commands file command, used in its template as {{.name}}
+// ------------------------------------
+type CommandParam struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Default     string   `yaml:"default"`
+	Required    bool     `yaml:"required"`
+	Regex       string   `yaml:"regex"`
+	Enum        []string `yaml:"enum"`
+	Description string   `yaml:"description"`
+}
+
+// validate checks a parameter value against its type, its regex and its enum
+func (param CommandParam) validate(value string) (string, error) {
+	switch param.Type {
+	case "", ParamString:
+	case ParamInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("error: parameter '%v' must be an int, got '%v'", param.Name, value)
+		}
+	case ParamBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("error: parameter '%v' must be a bool, got '%v'", param.Name, value)
+		}
+		value = strconv.FormatBool(b)
+	default:
+		return "", fmt.Errorf("error: parameter '%v' has an unsupported type '%v'", param.Name, param.Type)
+	}
+
+	if param.Regex != "" {
+		pattern, err := regexp.Compile("^(?:" + param.Regex + ")$")
+		if err != nil {
+			return "", fmt.Errorf("error: parameter '%v' has an invalid regex: %v", param.Name, err)
+		}
+		if !pattern.MatchString(value) {
+			return "", fmt.Errorf("error: parameter '%v' must match '%v', got '%v'", param.Name, param.Regex, value)
+		}
+	}
+	if len(param.Enum) > 0 {
+		for _, allowed := range param.Enum {
+			if value == allowed {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("error: parameter '%v' must be one of [%v], got '%v'", param.Name, strings.Join(param.Enum, ", "), value)
+	}
+	return value, nil
+}
+
+// getSignature returns the short usage of the parameter shown by --commands
+func (param CommandParam) getSignature() string {
+	kind := param.Type
+	if kind == "" {
+		kind = ParamString
+	}
+	if len(param.Enum) > 0 {
+		kind = strings.Join(param.Enum, "|")
+	}
+	signature := fmt.Sprintf("%v=<%v>", param.Name, kind)
+	if param.Required {
+		return signature
+	}
+	return fmt.Sprintf("[%v]", signature)
+}
+
+// applyParams validates the key=value arguments against the command parameters and sets the shell quoted values
+// used by its template ; the other arguments are appended to the command as before
+func applyParams(command *Command, args []string) error {
+	given := make(map[string]string)
+	var extraArgs []string
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || len(command.Params) == 0 {
+			extraArgs = append(extraArgs, arg)
+			continue
+		}
+		if command.getParam(name) == nil {
+			return fmt.Errorf("error: unknown parameter '%v' for command '%v'", name, command.Name)
+		}
+		given[name] = value
+	}
+
+	command.ParamValues = make(map[string]string)
+	for _, param := range command.Params {
+		value, ok := given[param.Name]
+		if !ok {
+			if param.Required {
+				return fmt.Errorf("error: command '%v' requires the parameter '%v'", command.Name, param.Name)
+			}
+			value = param.Default
+			if value == "" {
+				command.ParamValues[param.Name] = shellQuote(value)
+				continue
+			}
+		}
+		value, err := param.validate(value)
+		if err != nil {
+			return err
+		}
+		command.ParamValues[param.Name] = shellQuote(value)
+	}
+	if len(extraArgs) > 0 {
+		command.Args = strings.Join(extraArgs, " ")
+		if command.Template {
+			command.Args = escapeTemplate(command.Args)
+		}
+	}
+	return nil
+}
+
+// getParam returns the declared parameter with the name, nil if the command has none
+func (command *Command) getParam(name string) *CommandParam {
+	for i := range command.Params {
+		if command.Params[i].Name == name {
+			return &command.Params[i]
+		}
+	}
+	return nil
+}
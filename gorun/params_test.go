@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestCommandParamValidate(t *testing.T) {
+	tests := []struct {
+		param CommandParam
+		value string
+		valid string
+		fails bool
+	}{
+		{CommandParam{Name: "path"}, "/var/lib", "/var/lib", false},
+		{CommandParam{Name: "n", Type: ParamInt}, "42", "42", false},
+		{CommandParam{Name: "n", Type: ParamInt}, "4x", "", true},
+		{CommandParam{Name: "all", Type: ParamBool}, "1", "true", false},
+		{CommandParam{Name: "all", Type: ParamBool}, "yes", "", true},
+		{CommandParam{Name: "x", Type: "float"}, "1", "", true},
+		{CommandParam{Name: "path", Regex: "/.*"}, "/tmp", "/tmp", false},
+		{CommandParam{Name: "path", Regex: "/.*"}, "tmp/x", "", true},
+		{CommandParam{Name: "path", Regex: "/[a-z]+"}, "/tmp/x", "", true},
+		{CommandParam{Name: "path", Regex: "(["}, "/tmp", "", true},
+		{CommandParam{Name: "level", Enum: []string{"info", "debug"}}, "debug", "debug", false},
+		{CommandParam{Name: "level", Enum: []string{"info", "debug"}}, "trace", "", true},
+	}
+	for _, test := range tests {
+		valid, err := test.param.validate(test.value)
+		if test.fails != (err != nil) {
+			t.Errorf("validate(%+v, %q) error = %v, expected failure %v", test.param, test.value, err, test.fails)
+			continue
+		}
+		if valid != test.valid {
+			t.Errorf("validate(%+v, %q) = %q, expected %q", test.param, test.value, valid, test.valid)
+		}
+	}
+}
+
+func TestApplyParams(t *testing.T) {
+	command := Command{
+		Name: "disk usage",
+		Args: "-x",
+		Params: []CommandParam{
+			{Name: "path", Default: "/var/lib/docker", Regex: "/.*"},
+			{Name: "depth", Type: ParamInt},
+			{Name: "unit", Required: true, Enum: []string{"k", "m"}},
+		},
+	}
+	tests := []struct {
+		args   []string
+		values map[string]string
+		extra  string
+		fails  bool
+	}{
+		{[]string{"unit=k"}, map[string]string{"path": "'/var/lib/docker'", "depth": "''", "unit": "'k'"}, "-x", false},
+		{[]string{"unit=m", "path=/tmp/a b", "depth=2"}, map[string]string{"path": "'/tmp/a b'", "depth": "'2'", "unit": "'m'"}, "-x", false},
+		{[]string{"unit=k", "path=/x'; echo INJECTED"}, map[string]string{"path": `'/x'\''; echo INJECTED'`}, "-x", false},
+		{[]string{"unit=k", "--all"}, nil, "--all", false},
+		{[]string{"path=/tmp"}, nil, "", true},
+		{[]string{"unit=g"}, nil, "", true},
+		{[]string{"unit=k", "depth=x"}, nil, "", true},
+		{[]string{"unit=k", "size=1"}, nil, "", true},
+	}
+	for _, test := range tests {
+		applied := command
+		err := applyParams(&applied, test.args)
+		if test.fails != (err != nil) {
+			t.Errorf("applyParams(%q) error = %v, expected failure %v", test.args, err, test.fails)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		for name, value := range test.values {
+			if applied.ParamValues[name] != value {
+				t.Errorf("applyParams(%q) %v = %q, expected %q", test.args, name, applied.ParamValues[name], value)
+			}
+		}
+		if applied.Args != test.extra {
+			t.Errorf("applyParams(%q) args = %q, expected %q", test.args, applied.Args, test.extra)
+		}
+	}
+}
+
+// TestTimedParamsQuoting runs a timed command locally ; a param with quotes and a ; must come through unchanged
+func TestTimedParamsQuoting(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("timeout is not installed")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	command := Command{
+		Name:     "show path",
+		Command:  "printf %s {{.path}}",
+		Params:   []CommandParam{{Name: "path", Regex: "/.*"}},
+		Template: true,
+	}
+	for _, value := range []string{"/tmp/x", "/tmp/x; echo INJECTED", "/tmp/x'; echo INJECTED; '", `/tmp/"$(echo INJECTED)"`} {
+		applied := command
+		if err := applyParams(&applied, []string{"path=" + value}); err != nil {
+			t.Fatal(err)
+		}
+		nodes := Nodes{Node{Client: SSH{Server: "local"}}}
+		if err := renderCommandOnHosts(applied, nodes); err != nil {
+			t.Fatal(err)
+		}
+		output, err := exec.Command("bash", "-c", getTimeoutCommand(nodes[0].Command, 10)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		if string(output) != value {
+			t.Errorf("the timed command printed %q for the param %q", output, value)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConnectionPool pre-defined struct ; the authenticated connections of a run, keyed by user@server:port,
+// each one multiplexing the sessions of all the commands run on the host
+// ------------------------------------
+type ConnectionPool struct {
+	lock        sync.Mutex
+	connections map[string]*pooledConnection
+}
+
+type pooledConnection struct {
+	lock      sync.Mutex
+	sshClient SSH
+	connected bool
+	attempts  int
+	stop      chan struct{}
+}
+
+// Pool global instance containing the connections opened during the run
+var Pool = newConnectionPool()
+
+func newConnectionPool() *ConnectionPool {
+	return &ConnectionPool{connections: make(map[string]*pooledConnection)}
+}
+
+func getPoolKey(sshClient SSH) string {
+	return fmt.Sprintf("%v@%v:%v", sshClient.User, sshClient.Server, sshClient.Port)
+}
+
+// Get returns the host with its pooled connection and the attempts it took to connect it ; the host is connected
+// on first use or when the previous connection died, retrying the transient failures as set in the policy
+func (pool *ConnectionPool) Get(sshClient SSH, policy RetryPolicy) (SSH, int, error) {
+	key := getPoolKey(sshClient)
+	pool.lock.Lock()
+	conn, ok := pool.connections[key]
+	if !ok {
+		conn = &pooledConnection{}
+		pool.connections[key] = conn
+	}
+	pool.lock.Unlock()
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.connected {
+		return conn.sshClient, conn.attempts, nil
+	}
+
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		sshClient.attempt = attempts
+		err = sshClient.Connect(Config.AuthType)
+		if err == nil || attempts >= policy.Attempts || !isRetryable(err) {
+			break
+		}
+		time.Sleep(policy.getDelay(attempts))
+	}
+	if err != nil {
+		return sshClient, attempts, err
+	}
+	sshClient.session = nil
+	conn.sshClient = sshClient
+	conn.connected = true
+	conn.attempts = attempts
+	conn.stop = make(chan struct{})
+	if Config.KeepAliveInterval > 0 {
+		go conn.keepAlive(time.Duration(Config.KeepAliveInterval)*time.Second, conn.stop)
+	}
+	return sshClient, attempts, nil
+}
+
+// Invalidate closes the pooled connection of the host, the next Get reconnects it
+func (pool *ConnectionPool) Invalidate(sshClient SSH) {
+	pool.lock.Lock()
+	conn, ok := pool.connections[getPoolKey(sshClient)]
+	pool.lock.Unlock()
+	if ok {
+		conn.lock.Lock()
+		conn.close()
+		conn.lock.Unlock()
+	}
+}
+
+// Close closes all the pooled connections and the bastion connections behind them
+func (pool *ConnectionPool) Close() {
+	pool.lock.Lock()
+	for key, conn := range pool.connections {
+		conn.lock.Lock()
+		conn.close()
+		conn.lock.Unlock()
+		delete(pool.connections, key)
+	}
+	pool.lock.Unlock()
+	closeJumpClients()
+}
+
+// close must be called with the connection lock held
+func (conn *pooledConnection) close() {
+	if !conn.connected {
+		return
+	}
+	close(conn.stop)
+	conn.sshClient.Close()
+	conn.connected = false
+}
+
+// keepAlive sends keepalive requests until the pool closes the connection ; a failed request marks it dead
+func (conn *pooledConnection) keepAlive(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			conn.lock.Lock()
+			if !conn.connected {
+				conn.lock.Unlock()
+				return
+			}
+			client := conn.sshClient.client
+			conn.lock.Unlock()
+
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				conn.lock.Lock()
+				if conn.stop == stop {
+					conn.close()
+				}
+				conn.lock.Unlock()
+				return
+			}
+		}
+	}
+}
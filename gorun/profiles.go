@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// applyProfile layers the keys of the named profile over the configuration files ; the profile names are
+// lowercased by the configuration reader, so they are matched ignoring the case
+func applyProfile(config *Configs, name string) error {
+	keys, ok := config.Profiles[strings.ToLower(name)]
+	if !ok {
+		profiles := getProfileNames(config.Profiles)
+		if len(profiles) == 0 {
+			return fmt.Errorf("error: unknown profile '%v', no Profiles are set in the configuration files", name)
+		}
+		return fmt.Errorf("error: unknown profile '%v', the profiles are: %v", name, strings.Join(profiles, ", "))
+	}
+
+	viperRuntime := viper.New()
+	err := viperRuntime.MergeConfigMap(keys)
+	if err != nil {
+		return fmt.Errorf("error: invalid profile '%v': %v", name, err)
+	}
+	for _, key := range viperRuntime.AllKeys() {
+		key, _, _ = strings.Cut(key, ".")
+		if field := getConfigFieldName(key, ""); field == "Env" || field == "Profiles" {
+			return fmt.Errorf("error: invalid profile '%v': %v cannot be set by a profile", name, field)
+		}
+	}
+	// the relative paths of the profiles were resolved when their configuration file was read
+	err = applyConfigLayer(config, viperRuntime, "profile "+strings.ToLower(name), "")
+	if err != nil {
+		return fmt.Errorf("error: invalid profile '%v': %v", name, err)
+	}
+	return nil
+}
+
+// getProfileNames returns the sorted names of the profiles
+func getProfileNames(profiles map[string]map[string]interface{}) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkSafetyRules enforces the MaxHosts and Confirm rules, usually set by the profile, before running on the hosts
+func checkSafetyRules(nodes Nodes) error {
+	if Config.MaxHosts > 0 && len(nodes) > Config.MaxHosts {
+		return fmt.Errorf("error: %v hosts matched, more than the %v allowed by MaxHosts from %v ; narrow the hosts or set --max-hosts",
+			len(nodes), Config.MaxHosts, ConfigSources["MaxHosts"])
+	}
+	if Config.Confirm {
+		return confirmRun(nodes)
+	}
+	return nil
+}
+
+// confirmRun asks to type the profile name, or yes without a profile, before running on the hosts ; the answer
+// is read from the terminal as stdin may be the pipe passed to the command
+func confirmRun(nodes Nodes) error {
+	answer := Config.Env
+	if answer == "" {
+		answer = "yes"
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return fmt.Errorf("error: Confirm from %v requires a terminal ; use --confirm=false to run without it",
+			ConfigSources["Confirm"])
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(os.Stderr, "%v\n", Yellow(fmt.Sprintf("%v%v hosts matched", getEnvBannerPart(), len(nodes))))
+	fmt.Fprintf(os.Stderr, "type '%v' to confirm: ", answer)
+	line, _ := bufio.NewReader(tty).ReadString('\n')
+	if strings.TrimSpace(line) != answer {
+		return fmt.Errorf("error: not confirmed, nothing was run")
+	}
+	return nil
+}
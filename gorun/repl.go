@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// replHistoryFile keeps the lines typed in the REPL across the sessions
+const replHistoryFile = "~/.gorun/repl_history"
+
+// maxReplHistory bounds the history kept in memory and reloaded from replHistoryFile
+const maxReplHistory = 1000
+
+// replHistory is the line history of the REPL terminal ; the lines are also appended to replHistoryFile
+type replHistory struct {
+	lines []string
+	file  string
+}
+
+func readReplHistory(fileName string) *replHistory {
+	history := &replHistory{file: expandHome(fileName)}
+	content, err := os.ReadFile(history.file)
+	if err != nil {
+		return history
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			history.lines = append(history.lines, line)
+		}
+	}
+	if len(history.lines) > maxReplHistory {
+		history.lines = history.lines[len(history.lines)-maxReplHistory:]
+	}
+	return history
+}
+
+func (history *replHistory) Add(entry string) {
+	if entry == "" || (len(history.lines) > 0 && history.lines[len(history.lines)-1] == entry) {
+		return
+	}
+	history.lines = append(history.lines, entry)
+	if len(history.lines) > maxReplHistory {
+		history.lines = history.lines[1:]
+	}
+	file, err := os.OpenFile(history.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, entry)
+}
+
+func (history *replHistory) Len() int {
+	return len(history.lines)
+}
+
+// At returns the entries from the most recent one, as expected by term.Terminal
+func (history *replHistory) At(idx int) string {
+	return history.lines[len(history.lines)-1-idx]
+}
+
+// replSession pre-defined struct ; the hosts of a REPL session
+// ------------------------------------
+type replSession struct {
+	inventory Nodes
+	matched   Nodes
+	active    Nodes
+}
+
+// replLineReader reads the REPL input ; with line editing and history on a terminal, line by line otherwise
+type replLineReader struct {
+	fd       int
+	terminal *term.Terminal
+	reader   *bufio.Reader
+}
+
+func newReplLineReader() *replLineReader {
+	lineReader := &replLineReader{fd: int(os.Stdin.Fd())}
+	if term.IsTerminal(lineReader.fd) {
+		lineReader.terminal = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		lineReader.terminal.History = readReplHistory(replHistoryFile)
+	} else {
+		lineReader.reader = bufio.NewReader(os.Stdin)
+	}
+	return lineReader
+}
+
+// readLine switches the terminal to raw mode only while the line is edited, the commands output needing the cooked mode
+func (lineReader *replLineReader) readLine(prompt string) (string, error) {
+	if lineReader.terminal == nil {
+		fmt.Print(prompt)
+		line, err := lineReader.reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	state, err := term.MakeRaw(lineReader.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(lineReader.fd, state)
+	lineReader.terminal.SetPrompt(prompt)
+	line, err := lineReader.terminal.ReadLine()
+	return strings.TrimSpace(line), err
+}
+
+// runRepl reads commands and runs each one on the active hosts, over the connections kept open by the pool
+func runRepl(inventory Nodes, matched Nodes) {
+	session := &replSession{inventory: inventory, matched: matched, active: append(Nodes{}, matched...)}
+	connected := connectHosts(session.active)
+	fmt.Printf("connected to %v/%v host(s) ; type :help for the meta-commands\n", connected, len(session.active))
+
+	lineReader := newReplLineReader()
+	for {
+		line, err := lineReader.readLine(fmt.Sprintf("gorun [%v hosts]> ", len(session.active)))
+		if err != nil {
+			fmt.Println()
+			return
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			if session.runMetaCommand(line) {
+				return
+			}
+			continue
+		}
+		if len(session.active) == 0 {
+			fmt.Fprintf(os.Stderr, "%v\n", Red("error: no active host, use :hosts or :all"))
+			continue
+		}
+
+		for i := range session.active {
+			session.active[i].Result = CommandResult{}
+			session.active[i].Output = ""
+			session.active[i].Skipped = false
+		}
+		var command Command
+		command.Name = line
+		command.Command = line
+		runCommandOnHosts(command, session.active)
+		fmt.Println()
+	}
+}
+
+// runMetaCommand runs a REPL meta-command and returns true when the session ends
+func (session *replSession) runMetaCommand(line string) bool {
+	fields := strings.Fields(line)
+	name := fields[0]
+	argument := strings.TrimSpace(strings.TrimPrefix(line, name))
+
+	switch name {
+	case ":quit", ":exit", ":q":
+		return true
+
+	case ":help":
+		fmt.Println(`Meta-commands :
+	:hosts              list the active hosts
+	:hosts <pattern>    set the active hosts to the hosts matching the pattern, from all the hosts files
+	:add <pattern>      add the hosts matching the pattern to the active hosts
+	:exclude <pattern>  remove the hosts matching the pattern from the active hosts
+	:failed             keep only the hosts which did not pass the last command
+	:all                go back to the hosts matched on the command line
+	:quit               end the session`)
+
+	case ":hosts":
+		if argument == "" {
+			listMatchedHosts(session.active)
+			break
+		}
+		hosts, err := matchHost(argument, session.inventory)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", Red(err.Error()))
+			break
+		}
+		// the new active hosts follow the MaxHosts and Confirm rules, the active hosts staying as they were
+		if err := checkSafetyRules(hosts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", Red(err.Error()))
+			break
+		}
+		session.active = hosts
+		session.connectActive()
+
+	case ":add":
+		hosts, err := matchHost(argument, session.inventory)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", Red(err.Error()))
+			break
+		}
+		active := append(Nodes{}, session.active...)
+		for _, host := range hosts {
+			if !containsNode(active, host) {
+				active = append(active, host)
+			}
+		}
+		if err := checkSafetyRules(active); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", Red(err.Error()))
+			break
+		}
+		session.active = active
+		session.connectActive()
+
+	case ":exclude":
+		excluded, err := matchHost(argument, session.active)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", Red(err.Error()))
+			break
+		}
+		var hosts Nodes
+		for _, host := range session.active {
+			if !containsNode(excluded, host) {
+				hosts = append(hosts, host)
+			}
+		}
+		session.active = hosts
+
+	case ":failed":
+		var hosts Nodes
+		for _, host := range session.active {
+			if host.Result.Status != "" && getNodeStatus(host) != StatusPassed {
+				hosts = append(hosts, host)
+			}
+		}
+		session.active = hosts
+
+	case ":all":
+		session.active = append(Nodes{}, session.matched...)
+		session.connectActive()
+
+	default:
+		fmt.Fprintf(os.Stderr, "%v\n", Red(fmt.Sprintf("error: unknown meta-command '%v', type :help", name)))
+		return false
+	}
+
+	fmt.Printf("%v active host(s)\n", len(session.active))
+	return false
+}
+
+// connectActive opens the connections of the hosts added to the session
+func (session *replSession) connectActive() {
+	connected := connectHosts(session.active)
+	if connected < len(session.active) {
+		fmt.Fprintf(os.Stderr, "%v\n", Yellow(fmt.Sprintf("%v host(s) unreachable", len(session.active)-connected)))
+	}
+}
+
+// containsNode tells if the host is in the list, comparing the address and the user
+func containsNode(nodes Nodes, node Node) bool {
+	for _, existing := range nodes {
+		if existing.Client.getAddress() == node.Client.getAddress() && existing.Client.User == node.Client.User {
+			return true
+		}
+	}
+	return false
+}
+
+// connectHosts opens the pooled connections of the hosts in parallel and returns the number of connected hosts
+func connectHosts(nodes Nodes) int {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var forks chan struct{}
+	if Config.Forks > 0 {
+		forks = make(chan struct{}, Config.Forks)
+	}
+	connected := 0
+	policy := getRetryPolicy(Command{})
+	for _, node := range nodes {
+		if forks != nil {
+			forks <- struct{}{}
+		}
+		wg.Add(1)
+		go func(sshClient SSH) {
+			defer wg.Done()
+			_, _, err := Pool.Get(sshClient, policy)
+			if forks != nil {
+				<-forks
+			}
+			if err == nil {
+				lock.Lock()
+				connected++
+				lock.Unlock()
+			}
+		}(node.Client)
+	}
+	wg.Wait()
+	return connected
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReplMetaCommandsSafetyRules(t *testing.T) {
+	Config.MaxHosts = 2
+	defer func() { Config.MaxHosts = 0 }()
+	var inventory Nodes
+	for _, server := range []string{"web1", "web2", "web3"} {
+		var node Node
+		node.Client.Server = server
+		inventory = append(inventory, node)
+	}
+
+	tests := []struct {
+		line    string
+		pattern string
+		active  int
+	}{
+		{":hosts web", "web", 1},
+		{":add web(2|3)", "web(2|3)", 1},
+	}
+	for _, test := range tests {
+		if hosts, err := matchHost(test.pattern, inventory); err != nil || len(hosts) < 2 {
+			t.Fatalf("%v matched %v hosts: %v", test.pattern, len(hosts), err)
+		}
+		session := &replSession{inventory: inventory, matched: inventory[:1], active: append(Nodes{}, inventory[:1]...)}
+		session.runMetaCommand(test.line)
+		if len(session.active) != test.active {
+			t.Errorf("%v over MaxHosts left %v active hosts, expected %v", test.line, len(session.active), test.active)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRetryBackoff caps the exponential backoff between two connection attempts
+const maxRetryBackoff = 60 * time.Second
+
+// RetryPolicy pre-defined struct ; how many times a host connection is attempted and the wait between the attempts
+// ------------------------------------
+type RetryPolicy struct {
+	Attempts int
+	Backoff  int
+	Jitter   int
+}
+
+// getRetryPolicy returns the retry settings of the command, falling back to the config.yaml ones
+func getRetryPolicy(command Command) RetryPolicy {
+	policy := RetryPolicy{Attempts: Config.RetryAttempts, Backoff: Config.RetryBackoff, Jitter: Config.RetryJitter}
+	if command.Attempts > 0 {
+		policy.Attempts = command.Attempts
+	}
+	if command.Backoff > 0 {
+		policy.Backoff = command.Backoff
+	}
+	if command.Jitter > 0 {
+		policy.Jitter = command.Jitter
+	}
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	return policy
+}
+
+// getDelay returns the wait after a failed attempt ; the backoff doubled after each attempt plus a random jitter
+func (policy RetryPolicy) getDelay(attempt int) time.Duration {
+	delay := time.Duration(policy.Backoff) * time.Millisecond
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay = delay * 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	if policy.Jitter > 0 {
+		delay = delay + time.Duration(rand.Intn(policy.Jitter+1))*time.Millisecond
+	}
+	return delay
+}
+
+// isRetryable tells if a connection error is transient ; DNS failures, refused connections, timeouts
+// and handshakes reset by the server. Authentication and host key failures are never retried.
+func isRetryable(err error) bool {
+	var authErr *AuthError
+	var hostKeyErr *HostKeyError
+	if errors.As(err, &authErr) || errors.As(err, &hostKeyErr) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return strings.Contains(err.Error(), "handshake")
+	}
+	return false
+}
@@ -1,36 +1,47 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/tabwriter"
 	"time"
 	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
 )
 
 // Command pre-defined struct
 // ------------------------------------
 type Command struct {
-	Name        string `yaml:"name"`
-	Command     string `yaml:"command"`
-	Args        string `yaml:"args"`
-	Description string `yaml:"description"`
-	Header      string `yaml:"header"`
-	Timeout     int    `yaml:"timeout"`
+	Name        string         `yaml:"name"`
+	Command     string         `yaml:"command"`
+	Args        string         `yaml:"args"`
+	Description string         `yaml:"description"`
+	Header      string         `yaml:"header"`
+	Timeout     int            `yaml:"timeout"`
+	Attempts    int            `yaml:"attempts"`
+	Backoff     int            `yaml:"backoff"`
+	Jitter      int            `yaml:"jitter"`
+	Become      bool           `yaml:"become"`
+	Params      []CommandParam `yaml:"params"`
+	Template    bool
 	Pipe        string
+	ParamValues map[string]string
+	Transfer    *Transfer
 	Output      string
 	ReturnCode  int
 }
 
 func initCommands(commands []Command) {
-	for _, command := range commands {
-		if command.Timeout == 0 {
-			command.Timeout = Config.CommandDefaultTimeout
+	for i := range commands {
+		if commands[i].Timeout == 0 {
+			commands[i].Timeout = Config.CommandDefaultTimeout
 		}
 	}
 }
@@ -43,125 +54,259 @@ func printTabbedTable(lines []string) {
 	writer.Flush()
 }
 
+// getEnvBannerPart returns the active profile part of the banners, empty without a profile
+func getEnvBannerPart() string {
+	if Config.Env == "" {
+		return ""
+	}
+	return fmt.Sprintf("env: %v | ", Config.Env)
+}
+
 func getDefaultBanner(command string, duration string, rc string, sshClient SSH) string {
 	var banner string
+	env := getEnvBannerPart()
 	command = strings.ReplaceAll(command, "\n", " ")
 	if len(command) > 27 {
 		command = fmt.Sprintf("%v...", command[0:27])
 	}
 	x := strings.Repeat("-",
-		utf8.RuneCountInString(sshClient.Server)+
+		utf8.RuneCountInString(env)+
+			utf8.RuneCountInString(sshClient.Server)+
 			utf8.RuneCountInString(sshClient.Port)+
 			utf8.RuneCountInString(command)+utf8.RuneCountInString(duration)+
 			utf8.RuneCountInString(rc)+
 			37)
 	banner = banner + fmt.Sprintf("%v\n", x)
-	banner = banner + fmt.Sprintf("| %v:%v | command: %v | duration: %v | rc: %v |\n",
-		sshClient.Server, sshClient.Port, command, duration, rc)
+	banner = banner + fmt.Sprintf("| %v%v:%v | command: %v | duration: %v | rc: %v |\n",
+		env, sshClient.Server, sshClient.Port, command, duration, rc)
 	banner = banner + fmt.Sprintf("%v\n", x)
 
 	return banner
 }
 
-func getSummaryBanner(command string, duration string, passed string, failed string, total string) string {
+func getSummaryBanner(command string, duration string, passed string, failed string, unreachable string, skipped string, total string) string {
 	var banner string
+	env := getEnvBannerPart()
 	x := strings.Repeat("-",
-		utf8.RuneCountInString(command)+
+		utf8.RuneCountInString(env)+
+			utf8.RuneCountInString(command)+
 			utf8.RuneCountInString(duration)+
 			utf8.RuneCountInString(passed)+
 			utf8.RuneCountInString(failed)+
+			utf8.RuneCountInString(unreachable)+
+			utf8.RuneCountInString(skipped)+
 			utf8.RuneCountInString(total)+
-			68)
+			96)
 	banner = banner + fmt.Sprintf("%v\n", x)
-	banner = banner + fmt.Sprintf("| summary | command: %v | duration: %v | passed: %v | failed: %v | total: %v |\n",
-		command, duration, passed, failed, total)
+	banner = banner + fmt.Sprintf("| summary | %vcommand: %v | duration: %v | passed: %v | failed: %v | unreachable: %v | skipped: %v | total: %v |\n",
+		env, command, duration, passed, failed, unreachable, skipped, total)
 	banner = banner + fmt.Sprintf("%v\n", x)
 
 	return banner
 }
 
+// getBatchSize returns the number of hosts in a rolling batch based on the serial value
+// which is either a number of hosts ("5") or a percentage of the hosts ("10%")
+func getBatchSize(hostsCount int, serial string) (int, error) {
+	serial = strings.TrimSpace(serial)
+	if serial == "" {
+		return hostsCount, nil
+	}
+	if strings.HasSuffix(serial, "%") {
+		percentage, err := strconv.Atoi(strings.TrimSuffix(serial, "%"))
+		if err != nil || percentage < 0 || percentage > 100 {
+			return 0, fmt.Errorf("error: invalid serial percentage '%v'", serial)
+		}
+		// any zero percentage, 0% or 00%, is a single batch
+		if percentage == 0 {
+			return hostsCount, nil
+		}
+		size := (hostsCount*percentage + 99) / 100
+		if size < 1 {
+			size = 1
+		}
+		return size, nil
+	}
+	size, err := strconv.Atoi(serial)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("error: invalid serial value '%v'", serial)
+	}
+	// any zero value, 0, 00 or -0, is a single batch ; a batch of 0 hosts would never end the run
+	if size == 0 || size > hostsCount {
+		size = hostsCount
+	}
+	return size, nil
+}
+
 func runCommandOnHosts(command Command, sshClients Nodes) {
+	tt1 := time.Now()
+	batchSize, err := getBatchSize(len(sshClients), Config.Serial)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+	err = renderCommandOnHosts(command, sshClients)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+	for first := 0; first < len(sshClients); first += batchSize {
+		last := first + batchSize
+		if last > len(sshClients) {
+			last = len(sshClients)
+		}
+		failed := runCommandOnBatch(command, sshClients[first:last])
+		if failed > 0 && Config.AbortOnFail && last < len(sshClients) {
+			fmt.Fprintf(os.Stderr, "%v\n\n", Red(fmt.Sprintf("aborting: %v host(s) failed in the batch, skipping the remaining %v host(s)",
+				failed, len(sshClients)-last)))
+			for i := last; i < len(sshClients); i++ {
+				sshClients[i].Skipped = true
+			}
+			break
+		}
+	}
+	tdiff := time.Now().Sub(tt1)
+	totalDuration := fmt.Sprintf("%0.2vs", tdiff.Seconds())
+	if !isTextOutput() {
+		err = printStructuredOutput(sshClients, command.Name, tdiff)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	} else if command.Header != "" {
+		outputs := getAllOutputs(sshClients)
+		printOutputWithCustomBanner(command.Header, outputs)
+	} else if Config.Collapse {
+		printCollapsedOutput(sshClients)
+		printCommandSummary(sshClients, command.Name, totalDuration)
+	} else {
+		printCommandSummary(sshClients, command.Name, totalDuration)
+	}
+}
+
+// runCommandOnBatch runs the command on a batch of hosts, with at most Config.Forks hosts in parallel,
+// and returns the number of failed hosts once the whole batch finished
+func runCommandOnBatch(command Command, sshClients Nodes) int {
 	var wg sync.WaitGroup
+	var forks chan struct{}
+	if Config.Forks > 0 {
+		forks = make(chan struct{}, Config.Forks)
+	}
 
-	tt1 := time.Now()
+	runCommand := command.Command
+	if command.Args != "" {
+		runCommand = runCommand + " " + command.Args
+	}
 	for i := 0; i < len(sshClients); i++ {
-		c := make(chan string)
-		e := make(chan error)
+		if forks != nil {
+			forks <- struct{}{}
+		}
+		r := make(chan CommandResult)
 		t1 := time.Now()
 		wg.Add(1)
-		runCommand := command.Command
-		if command.Args != "" {
-			runCommand = runCommand + " " + command.Args
-		}
 
-		go runCommandParallel(runCommand, command.Pipe, command.Timeout, sshClients[i].Client, &wg, c, e)
+		// the command rendered with the host vars, if any
+		hostCommand := runCommand
+		if sshClients[i].Command != "" {
+			hostCommand = sshClients[i].Command
+		}
+		go runCommandParallel(command, hostCommand, sshClients[i].Client, r)
 		go func(sshClient *Node) {
 			defer wg.Done()
-			output := <-c
-			err := <-e
-			if err != nil {
-				sshClient.ReturnCode = 1
+			sshClient.Result = <-r
+			if forks != nil {
+				<-forks
 			}
 			tdiff := time.Now().Sub(t1)
+			sshClient.Result.Duration = tdiff
 			duration := fmt.Sprintf("%0.2vs", tdiff.Seconds())
-			rc := fmt.Sprintf("%v", sshClient.ReturnCode)
-			if command.Header == "" {
-				banner := getDefaultBanner(runCommand, duration, rc, sshClient.Client)
-				if sshClient.ReturnCode == 0 {
-					sshClient.Output = Green(banner) + Default(output)
+			rc := getResultCode(sshClient.Result)
+			if sshClient.Result.Attempts > 1 {
+				rc = fmt.Sprintf("%v | attempts: %v", rc, sshClient.Result.Attempts)
+			}
+			if !isTextOutput() {
+				sshClient.Output = sshClient.Result.Stdout
+			} else if command.Header == "" && Config.Collapse {
+				sshClient.Output = getRawOutput(sshClient.Result)
+			} else if command.Header == "" {
+				banner := getDefaultBanner(hostCommand, duration, rc, sshClient.Client)
+				if sshClient.Result.Status == StatusPassed {
+					sshClient.Output = Green(banner) + Default(sshClient.Result.Stdout)
 				} else {
-					sshClient.Output = Red(banner) + Black(output)
+					sshClient.Output = Red(banner) + Black(sshClient.Result.Stdout)
+				}
+				if sshClient.Result.Stderr != "" {
+					if sshClient.Result.Stdout != "" {
+						sshClient.Output = sshClient.Output + "\n"
+					}
+					sshClient.Output = sshClient.Output + Red(sshClient.Result.Stderr)
+				}
+				if isConnectionFailure(sshClient.Result.Status) {
+					sshClient.Output = sshClient.Output + Black(sshClient.Result.Error)
 				}
 				fmt.Printf("%v\n\n", sshClient.Output)
 			} else {
-				sshClient.Output = output
+				sshClient.Output = sshClient.Result.Stdout
 			}
 		}(&sshClients[i])
-		time.Sleep(10 * time.Millisecond)
 	}
 	wg.Wait()
-	tdiff := time.Now().Sub(tt1)
-	totalDuration := fmt.Sprintf("%0.2vs", tdiff.Seconds())
-	if command.Header != "" {
-		outputs := getAllOutputs(sshClients)
-		printOutputWithCustomBanner(command.Header, outputs)
-	} else {
-		printCommandSummary(sshClients, command.Name, totalDuration)
+
+	failed := 0
+	for i := 0; i < len(sshClients); i++ {
+		if sshClients[i].Result.Status != StatusPassed {
+			failed++
+		}
 	}
+	return failed
 }
 
 func getAllOutputs(sshClients Nodes) []string {
 	var outputs []string
 	for i := 0; i < len(sshClients); i++ {
+		if sshClients[i].Skipped {
+			continue
+		}
 		outputs = append(outputs, sshClients[i].Output)
 	}
 	return outputs
 }
 
 func printCommandSummary(sshClients Nodes, command string, duration string) {
-	var passed, failed int
+	var passed, failed, unreachable, skipped int
 	var summary []string
 
 	for i := 0; i < len(sshClients); i++ {
 		serverAndPort := fmt.Sprintf("%v:%v", sshClients[i].Client.Server, sshClients[i].Client.Port)
-		if sshClients[i].ReturnCode > 0 {
-			failed++
+		result := sshClients[i].Result
+		status := getNodeStatus(sshClients[i])
+		if status == StatusSkipped {
+			skipped++
 			if Config.SummaryDetails == "failed-only" || Config.SummaryDetails == "all" {
-				summary = append(summary, fmt.Sprintf("%v -> %v", serverAndPort, Red("FAILED")))
+				summary = append(summary, fmt.Sprintf("%v -> %v", serverAndPort, Yellow(StatusSkipped)))
 			}
-		} else {
+		} else if status == StatusPassed {
 			passed++
 			if Config.SummaryDetails == "passed-only" || Config.SummaryDetails == "all" {
-				summary = append(summary, fmt.Sprintf("%v -> %v", serverAndPort, Green("PASSED")))
+				summary = append(summary, fmt.Sprintf("%v -> %v", serverAndPort, Green(StatusPassed)))
+			}
+		} else {
+			if isConnectionFailure(status) {
+				unreachable++
+			} else {
+				failed++
+			}
+			if Config.SummaryDetails == "failed-only" || Config.SummaryDetails == "all" {
+				summary = append(summary, fmt.Sprintf("%v -> %v %v", serverAndPort, Red(result.Status), getResultDetails(result)))
 			}
 		}
 	}
 	total := len(sshClients)
-	banner := getSummaryBanner(command, duration, fmt.Sprintf("%v", passed), fmt.Sprintf("%v", failed), fmt.Sprintf("%v", total))
+	banner := getSummaryBanner(command, duration, fmt.Sprintf("%v", passed), fmt.Sprintf("%v", failed),
+		fmt.Sprintf("%v", unreachable), fmt.Sprintf("%v", skipped), fmt.Sprintf("%v", total))
 
 	if total == passed {
 		fmt.Printf("%v", Green(banner))
-	} else if total == failed {
+	} else if total == failed+unreachable {
 		fmt.Printf("%v", Red(banner))
 	} else {
 		fmt.Printf("%v", Yellow(banner))
@@ -172,57 +317,201 @@ func printCommandSummary(sshClients Nodes, command string, duration string) {
 	}
 }
 
-func runCommandParallel(command string, pipe string, timeout int, sshClient SSH, wg *sync.WaitGroup, c chan string, e chan error) {
-	if timeout > 0 {
-		command = fmt.Sprintf("timeout --kill-after=%v %v bash -c '%v'", timeout, timeout, command)
+// getConnectionResult classifies a connection failure ; host key errors are kept apart from the unreachable hosts
+func getConnectionResult(err error) CommandResult {
+	result := CommandResult{ReturnCode: -1, Status: StatusUnreachable, Error: err.Error()}
+	var hostKeyErr *HostKeyError
+	var authErr *AuthError
+	if errors.As(err, &hostKeyErr) {
+		if hostKeyErr.Mismatch {
+			result.Status = StatusHostKey
+		} else {
+			result.Status = StatusHostUnknown
+		}
+	} else if errors.As(err, &authErr) {
+		result.Status = StatusAuthFailed
+	}
+	return result
+}
+
+// isConnectionFailure tells if the host failed before running the command
+func isConnectionFailure(status string) bool {
+	return status == StatusUnreachable || status == StatusHostKey || status == StatusHostUnknown || status == StatusAuthFailed
+}
+
+// getNodeStatus returns the run status of a node, including the hosts skipped by a rolling run
+func getNodeStatus(node Node) string {
+	if node.Skipped {
+		return StatusSkipped
+	}
+	return node.Result.Status
+}
+
+// getCommandResult classifies the outcome of a command run, separating the remote exit status
+// from the errors raised by the ssh layer
+func getCommandResult(stdout string, stderr string, err error, timeout int) CommandResult {
+	result := CommandResult{Stdout: stdout, Stderr: stderr, Status: StatusPassed}
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	switch exitErr := err.(type) {
+	case *ssh.ExitError:
+		result.ReturnCode = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+		if result.Signal != "" {
+			result.Status = StatusSignaled
+		} else if timeout > 0 && (result.ReturnCode == 124 || result.ReturnCode == 137) {
+			result.Status = StatusTimeout
+		} else {
+			result.Status = StatusFailed
+		}
+	case *ssh.ExitMissingError:
+		result.ReturnCode = -1
+		result.Status = StatusNoExitStatus
+	default:
+		result.ReturnCode = -1
+		result.Status = StatusUnreachable
+	}
+	return result
+}
+
+// getResultCode returns the return code shown in the banners
+func getResultCode(result CommandResult) string {
+	switch result.Status {
+	case StatusTimeout:
+		return fmt.Sprintf("%v (timeout)", result.ReturnCode)
+	case StatusSignaled:
+		return fmt.Sprintf("- (signal %v)", result.Signal)
+	case StatusNoExitStatus:
+		return "- (no exit status)"
+	case StatusUnreachable:
+		return "- (unreachable)"
+	case StatusHostKey:
+		return "- (host key mismatch)"
+	case StatusHostUnknown:
+		return "- (unknown host key)"
+	case StatusAuthFailed:
+		return "- (authentication failed)"
+	}
+	return fmt.Sprintf("%v", result.ReturnCode)
+}
+
+// getRawOutput returns the uncolored output of a command run ; the ssh error for unreachable hosts
+func getRawOutput(result CommandResult) string {
+	if result.Error != "" && result.ReturnCode == -1 && result.Stdout == "" && result.Stderr == "" {
+		return result.Error
+	}
+	if result.Stdout != "" && result.Stderr != "" {
+		return result.Stdout + "\n" + result.Stderr
+	}
+	return result.Stdout + result.Stderr
+}
+
+// getResultDetails returns the failure details shown in the summary
+func getResultDetails(result CommandResult) string {
+	switch result.Status {
+	case StatusFailed, StatusTimeout:
+		return fmt.Sprintf("(rc: %v)", result.ReturnCode)
+	case StatusSignaled:
+		return fmt.Sprintf("(signal: %v)", result.Signal)
+	case StatusUnreachable, StatusHostKey, StatusHostUnknown, StatusAuthFailed:
+		if result.Attempts > 1 {
+			return fmt.Sprintf("(after %v attempts: %v)", result.Attempts, result.Error)
+		}
+		return fmt.Sprintf("(%v)", result.Error)
+	}
+	return ""
+}
+
+// getTimeoutCommand wraps the command in timeout ; the command is quoted whole, keeping the quoting of its params
+func getTimeoutCommand(command string, timeout int) string {
+	return fmt.Sprintf("timeout --kill-after=%v %v bash -c %v", timeout, timeout, shellQuote(command))
+}
+
+func runCommandParallel(command Command, runCommand string, sshClient SSH, r chan CommandResult) {
+	timeout := command.Timeout
+	if timeout > 0 && command.Transfer == nil {
+		runCommand = getTimeoutCommand(runCommand, timeout)
 	}
-	err := sshClient.Connect(Config.AuthType)
+	sshClient, attempts, err := Pool.Get(sshClient, getRetryPolicy(command))
 	if err != nil {
-		c <- fmt.Sprintln(err)
-		e <- err
+		result := getConnectionResult(err)
+		result.Attempts = attempts
+		r <- result
 		return
 	}
 
-	sshClient.RefreshSession()
-	commandOutput, err := sshClient.RunCommand(command, pipe)
-	c <- commandOutput
-	e <- err
+	if command.Transfer != nil {
+		output, err := sshClient.runTransfer(*command.Transfer, timeout)
+		result := CommandResult{Stdout: output, Status: StatusPassed, Attempts: attempts}
+		if err != nil {
+			result.ReturnCode = 1
+			result.Status = StatusFailed
+			result.Error = err.Error()
+			result.Stderr = err.Error()
+		}
+		r <- result
+		return
+	}
 
-	sshClient.Close()
+	err = sshClient.RefreshSession()
+	if err != nil {
+		Pool.Invalidate(sshClient)
+		r <- CommandResult{ReturnCode: -1, Status: StatusUnreachable, Error: err.Error(), Attempts: attempts}
+		return
+	}
+	defer sshClient.CloseSession()
+	var stdout, stderr string
+	if isBecome(command, sshClient) {
+		if command.Pipe != "" {
+			message := "error: the piped stdin cannot be used with become, sudo reads the password from the pty"
+			r <- CommandResult{ReturnCode: 1, Status: StatusFailed, Error: message, Stderr: message, Attempts: attempts}
+			return
+		}
+		stdout, stderr, err = sshClient.RunBecomeCommand(runCommand)
+	} else {
+		stdout, stderr, err = sshClient.RunCommand(runCommand, command.Pipe)
+	}
+	result := getCommandResult(stdout, stderr, err, timeout)
+	result.Attempts = attempts
+	r <- result
 }
 
 func printOutputWithCustomBanner(banner string, output []string) {
 	var lines []string
-	banner = fmt.Sprintf(banner)
 	lines = append(lines, banner)
 	lines = append(lines, output...)
 	printTabbedTable(lines)
 }
 
+// matchHost selects the hosts matching the host expression ; see hostSelector for its syntax.
+// The selected hosts keep the reasons they matched for, shown by --list.
 func matchHost(hostPatterns string, hostsList Nodes) (Nodes, error) {
 	var foundHosts Nodes
-	for _, pattern := range strings.Split(hostPatterns, ",") {
-		for _, host := range hostsList {
-			matched, err := regexp.MatchString(pattern, host.Client.Server)
-			if err == nil {
-				if matched {
-					exists := false
-					for _, existinghost := range foundHosts {
-						if host == existinghost {
-							exists = true
-							break
-						}
-					}
-					if exists == false {
-						foundHosts = append(foundHosts, host)
-					}
-				}
+	selector := &hostSelector{hosts: hostsList, groups: make(map[string]bool)}
+	set, err := selector.selectHosts(hostPatterns)
+	if err != nil {
+		return foundHosts, err
+	}
+	for _, i := range set.getSortedIndexes() {
+		host := hostsList[i]
+		exists := false
+		for _, existinghost := range foundHosts {
+			if host.Client.getAddress() == existinghost.Client.getAddress() && host.Client.User == existinghost.Client.User {
+				exists = true
+				break
 			}
 		}
-		if len(foundHosts) == 0 {
-			return foundHosts, fmt.Errorf("error: couldn't match any hosts using the provided pattern '%v'", hostPatterns)
+		if exists == false {
+			host.Matched = set[i]
+			foundHosts = append(foundHosts, host)
 		}
 	}
+	if len(foundHosts) == 0 {
+		return foundHosts, fmt.Errorf("error: couldn't match any hosts using the provided pattern '%v'", hostPatterns)
+	}
 
 	return foundHosts, nil
 }
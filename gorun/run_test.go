@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetBatchSize(t *testing.T) {
+	tests := []struct {
+		hostsCount int
+		serial     string
+		size       int
+		fails      bool
+	}{
+		{10, "", 10, false},
+		{10, "0", 10, false},
+		{10, "00", 10, false},
+		{10, "-0", 10, false},
+		{10, "0%", 10, false},
+		{10, "00%", 10, false},
+		{10, "3", 3, false},
+		{10, " 3 ", 3, false},
+		{10, "20", 10, false},
+		{10, "25%", 3, false},
+		{10, "100%", 10, false},
+		{3, "1%", 1, false},
+		{0, "5", 0, false},
+		{10, "-1", 0, true},
+		{10, "x", 0, true},
+		{10, "101%", 0, true},
+		{10, "-5%", 0, true},
+	}
+	for _, test := range tests {
+		size, err := getBatchSize(test.hostsCount, test.serial)
+		if test.fails {
+			if err == nil {
+				t.Errorf("getBatchSize(%v, %q) = %v, expected an error", test.hostsCount, test.serial, size)
+			}
+			continue
+		}
+		if err != nil || size != test.size {
+			t.Errorf("getBatchSize(%v, %q) = %v, %v, expected %v", test.hostsCount, test.serial, size, err, test.size)
+		}
+		if test.hostsCount > 0 && size < 1 {
+			t.Errorf("getBatchSize(%v, %q) = %v, a batch of no hosts never ends the run", test.hostsCount, test.serial, size)
+		}
+	}
+}
+
+func TestInitCommands(t *testing.T) {
+	Config.CommandDefaultTimeout = 300
+	commands := []Command{{Name: "uptime"}, {Name: "backup", Timeout: 3600}}
+	initCommands(commands)
+	if commands[0].Timeout != 300 || commands[1].Timeout != 3600 {
+		t.Errorf("initCommands set the timeouts %v and %v, expected 300 and 3600", commands[0].Timeout, commands[1].Timeout)
+	}
+}
+
+// runTestSession runs a command on an in-process ssh server ending the session with the given request,
+// exit-status or exit-signal, or with none ; it returns the error of the session as the ssh layer builds it
+func runTestSession(t *testing.T, request string, payload interface{}) error {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		serverSide, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer serverSide.Close()
+		_, chans, reqs, err := ssh.NewServerConn(serverSide, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			for req := range requests {
+				req.Reply(req.Type == "exec", nil)
+				if req.Type != "exec" {
+					continue
+				}
+				if request != "" {
+					channel.SendRequest(request, false, ssh.Marshal(payload))
+				}
+				channel.Close()
+			}
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	return session.Run("true")
+}
+
+func TestGetCommandResult(t *testing.T) {
+	type exitStatus struct {
+		Status uint32
+	}
+	type exitSignal struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}
+	tests := []struct {
+		request    string
+		payload    interface{}
+		timeout    int
+		status     string
+		returnCode int
+		code       string
+	}{
+		{"exit-status", exitStatus{0}, 0, StatusPassed, 0, "0"},
+		{"exit-status", exitStatus{1}, 0, StatusFailed, 1, "1"},
+		{"exit-status", exitStatus{124}, 0, StatusFailed, 124, "124"},
+		{"exit-status", exitStatus{124}, 10, StatusTimeout, 124, "124 (timeout)"},
+		{"exit-status", exitStatus{137}, 10, StatusTimeout, 137, "137 (timeout)"},
+		{"exit-signal", exitSignal{Signal: "KILL"}, 0, StatusSignaled, 137, "- (signal KILL)"},
+		{"exit-signal", exitSignal{Signal: "KILL"}, 10, StatusSignaled, 137, "- (signal KILL)"},
+		{"", nil, 0, StatusNoExitStatus, -1, "- (no exit status)"},
+	}
+	for _, test := range tests {
+		err := runTestSession(t, test.request, test.payload)
+		result := getCommandResult("out", "", err, test.timeout)
+		if result.Status != test.status || result.ReturnCode != test.returnCode || getResultCode(result) != test.code {
+			t.Errorf("%v %v with timeout %v: %v rc %v %q, expected %v rc %v %q", test.request, test.payload, test.timeout,
+				result.Status, result.ReturnCode, getResultCode(result), test.status, test.returnCode, test.code)
+		}
+		if result.Stdout != "out" {
+			t.Errorf("%v %v: the stdout %q was lost", test.request, test.payload, result.Stdout)
+		}
+	}
+
+	// an error of the ssh layer is not a remote exit status
+	result := getCommandResult("", "", errors.New("connection reset by peer"), 10)
+	if result.Status != StatusUnreachable || result.ReturnCode != -1 || result.Error != "connection reset by peer" {
+		t.Errorf("a connection error: %v rc %v %q", result.Status, result.ReturnCode, result.Error)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// openShellOnHosts opens an interactive shell on the matched host ; a picker is shown when several hosts match
+func openShellOnHosts(nodes Nodes) error {
+	if len(nodes) == 0 {
+		return errors.New("error: no host matches the pattern")
+	}
+	i := 0
+	if len(nodes) > 1 {
+		var err error
+		i, err = pickHost(nodes)
+		if err != nil {
+			return err
+		}
+	}
+	return nodes[i].Client.Shell()
+}
+
+// pickHost lists the matched hosts and asks for the one to connect to, by number or by server name
+func pickHost(nodes Nodes) (int, error) {
+	for i, node := range nodes {
+		fmt.Fprintf(os.Stderr, "%3v) %v@%v:%v\n", i+1, node.Client.User, node.Client.Server, node.Client.Port)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "select a host [1-%v]: ", len(nodes))
+		line, err := reader.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if n, convErr := strconv.Atoi(answer); convErr == nil && n >= 1 && n <= len(nodes) {
+			return n - 1, nil
+		}
+		for i, node := range nodes {
+			if answer != "" && (answer == node.Client.Server || answer == getHostLabel(node.Client)) {
+				return i, nil
+			}
+		}
+		if err != nil {
+			return 0, errors.New("error: no host selected")
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", Red(fmt.Sprintf("invalid selection '%v'", answer)))
+	}
+}
+
+// Shell opens an interactive session on the host, with the local terminal in raw mode
+// and its size changes forwarded to the remote pty
+func (sshClient *SSH) Shell() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("error: --ssh requires a terminal")
+	}
+
+	client, _, err := Pool.Get(*sshClient, getRetryPolicy(Command{}))
+	if err != nil {
+		return err
+	}
+	err = client.RefreshSession()
+	if err != nil {
+		Pool.Invalidate(client)
+		return err
+	}
+	defer client.CloseSession()
+	session := client.session
+
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	err = session.RequestPty(termType, height, width, modes)
+	if err != nil {
+		return err
+	}
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	resize := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	defer close(done)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-resize:
+				if width, height, err := term.GetSize(fd); err == nil {
+					session.WindowChange(height, width)
+				}
+			}
+		}
+	}()
+
+	err = session.Shell()
+	if err != nil {
+		return err
+	}
+	err = session.Wait()
+
+	// the exit status of the remote shell is the one of the last command typed, not a gorun failure
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPickHost(t *testing.T) {
+	nodes := Nodes{
+		{Client: SSH{Server: "web1", Port: "22", User: "root"}},
+		{Client: SSH{Server: "web2", Port: "2222", User: "root"}},
+		{Client: SSH{Server: "web3", Port: "22", User: "ops"}},
+	}
+	tests := []struct {
+		input   string
+		index   int
+		fails   bool
+		invalid int
+	}{
+		{"2\n", 1, false, 0},
+		{" 3 \n", 2, false, 0},
+		{"web3\n", 2, false, 0},
+		{"web2:2222\n", 1, false, 0},
+		{"web1", 0, false, 0},
+		{"0\nweb4\n\n1\n", 0, false, 3},
+		{"", 0, true, 0},
+		{"4\n", 0, true, 1},
+	}
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(file, []byte(test.input), 0600); err != nil {
+			t.Fatal(err)
+		}
+		input, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = input
+		var index int
+		prompt := captureFile(t, &os.Stderr, func() {
+			index, err = pickHost(nodes)
+		})
+		input.Close()
+		if (err != nil) != test.fails || (!test.fails && index != test.index) {
+			t.Errorf("pickHost(%q) = %v, %v, expected %v", test.input, index, err, test.index)
+		}
+		if !strings.Contains(prompt, "  2) root@web2:2222\n") || strings.Count(prompt, "invalid selection") != test.invalid {
+			t.Errorf("pickHost(%q) prompted:\n%v", test.input, prompt)
+		}
+	}
+
+	if err := openShellOnHosts(nil); err == nil {
+		t.Errorf("openShellOnHosts without hosts returned no error")
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bramvdbogaerde/go-scp"
@@ -18,32 +19,82 @@ var KeyFile string
 // SSH yaml pre-defined structures
 // ------------------------------------
 type SSH struct {
-	Server    string `yaml:"server"`
-	Port      string `yaml:"port"`
-	User      string `yaml:"user"`
-	Password  string `yaml:"password"`
-	Defaults  SSHDefaults
-	session   *ssh.Session
-	client    *ssh.Client
-	sshConfig *ssh.ClientConfig
+	Server     string            `yaml:"server"`
+	Port       string            `yaml:"port"`
+	User       string            `yaml:"user"`
+	Password   string            `yaml:"password"`
+	Auth       []string          `yaml:"auth"`
+	Key        string            `yaml:"key"`
+	Passphrase string            `yaml:"passphrase"`
+	Jump       string            `yaml:"jump"`
+	Become     *bool             `yaml:"become"`
+	Tags       []string          `yaml:"tags"`
+	Vars       map[string]string `yaml:"vars"`
+	Defaults   SSHDefaults
+	session    *ssh.Session
+	client     *ssh.Client
+	sshConfig  *ssh.ClientConfig
+	hostKeyErr *HostKeyError
+	agentConn  net.Conn
+	via        *ssh.Client
+	hostName   string
+	attempt    int
 }
 
 // SSHDefaults pre-defined struct
 // ------------------------------------
 type SSHDefaults struct {
-	Port     string `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Port       string            `yaml:"port"`
+	User       string            `yaml:"user"`
+	Password   string            `yaml:"password"`
+	Auth       []string          `yaml:"auth"`
+	Key        string            `yaml:"key"`
+	Passphrase string            `yaml:"passphrase"`
+	Jump       string            `yaml:"jump"`
+	Become     bool              `yaml:"become"`
+	Tags       []string          `yaml:"tags"`
+	Vars       map[string]string `yaml:"vars"`
 }
 
 // Node pre-defined struct
 // -----------------------
 type Node struct {
-	Client     SSH
-	Output     string
+	Client  SSH
+	Output  string
+	Result  CommandResult
+	Skipped bool
+	Group   string
+	Matched []string
+	Command string
+}
+
+// CommandResult pre-defined struct
+// --------------------------------
+type CommandResult struct {
+	Stdout     string
+	Stderr     string
 	ReturnCode int
+	Signal     string
+	Status     string
+	Error      string
+	Duration   time.Duration
+	Attempts   int
 }
 
+// Command result statuses
+const (
+	StatusPassed       = "PASSED"
+	StatusFailed       = "FAILED"
+	StatusTimeout      = "TIMEOUT"
+	StatusSignaled     = "SIGNALED"
+	StatusNoExitStatus = "NO-EXIT-STATUS"
+	StatusUnreachable  = "UNREACHABLE"
+	StatusHostKey      = "HOSTKEY-MISMATCH"
+	StatusHostUnknown  = "HOSTKEY-UNKNOWN"
+	StatusAuthFailed   = "AUTH-FAILED"
+	StatusSkipped      = "SKIPPED"
+)
+
 // Nodes pre-defined struct
 type Nodes []Node
 
@@ -63,122 +114,178 @@ func (sshClient *SSH) initHosts() {
 			fmt.Println(err)
 		}
 	}
+	if len(sshClient.Auth) == 0 {
+		sshClient.Auth = sshClient.Defaults.Auth
+	}
+	if sshClient.Key == "" {
+		sshClient.Key = sshClient.Defaults.Key
+	}
+	if sshClient.Jump == "" {
+		sshClient.Jump = sshClient.Defaults.Jump
+	}
+	// become is unset when the node has no become key, become: false opting out of the defaults one
+	if sshClient.Become == nil {
+		become := sshClient.Defaults.Become
+		sshClient.Become = &become
+	}
+	sshClient.Tags = appendUnique(sshClient.Tags, sshClient.Defaults.Tags)
+	for name, value := range sshClient.Defaults.Vars {
+		if sshClient.Vars == nil {
+			sshClient.Vars = make(map[string]string)
+		}
+		if _, ok := sshClient.Vars[name]; !ok {
+			sshClient.Vars[name] = value
+		}
+	}
+	keyFromSSHConfig := sshClient.applySSHConfig()
+	if sshClient.Port == "" {
+		sshClient.Port = "22"
+	}
+	if len(sshClient.Auth) == 0 && keyFromSSHConfig {
+		sshClient.Auth = []string{AuthKey}
+		if sshClient.Password != "" {
+			sshClient.Auth = append(sshClient.Auth, AuthPassword)
+		}
+	}
+	if sshClient.Passphrase == "" {
+		sshClient.Passphrase = sshClient.Defaults.Passphrase
+	} else {
+		var err error
+		sshClient.Passphrase, err = decrypt(KeyFile, sshClient.Passphrase)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
 }
 
-func (sshClient *SSH) readPublicKeyFile(file string) ssh.AuthMethod {
-	buffer, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil
+// getHostName returns the name used to dial the host ; the ssh_config HostName of the server alias, if any
+func (sshClient *SSH) getHostName() string {
+	if sshClient.hostName != "" {
+		return sshClient.hostName
 	}
+	return sshClient.Server
+}
 
-	key, err := ssh.ParsePrivateKey(buffer)
-	if err != nil {
-		return nil
-	}
-	return ssh.PublicKeys(key)
+// getAddress returns the server:port address used to dial the host
+func (sshClient *SSH) getAddress() string {
+	return net.JoinHostPort(sshClient.getHostName(), sshClient.Port)
 }
 
 // Connect function
 func (sshClient *SSH) Connect(mode int) error {
 
 	var sshConfig *ssh.ClientConfig
-	var auth []ssh.AuthMethod
-	if mode == 1 {
-		auth = []ssh.AuthMethod{ssh.Password(sshClient.Password)}
-	} else if mode == 2 {
-		auth = []ssh.AuthMethod{sshClient.readPublicKeyFile(sshClient.Password)}
-	} else {
-		err := errors.New(fmt.Sprintln("error: does not support mode: ", mode))
+	sshClient.hostKeyErr = nil
+	authNames, err := sshClient.getAuthMethodNames(mode)
+	if err != nil {
+		return err
+	}
+	auth, reasons := sshClient.getAuthMethods(authNames)
+	if len(auth) == 0 {
+		return &AuthError{User: sshClient.User, Address: sshClient.getAddress(), Methods: authNames, Reasons: reasons}
+	}
+
+	hostKeyCallback, hostKeyAlgorithms, err := sshClient.getHostKeyCallback()
+	if err != nil {
 		return err
 	}
 
 	sshConfig = &ssh.ClientConfig{
-		User: sshClient.User,
-		Auth: auth,
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-		Timeout: time.Duration(Config.SSHDefaultTimeout) * time.Second,
+		User:              sshClient.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           time.Duration(Config.SSHDefaultTimeout) * time.Second,
 	}
 	sshClient.sshConfig = sshConfig
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", sshClient.Server, sshClient.Port), sshConfig)
+	client, err := sshClient.dial(sshConfig)
 	if err != nil {
+		if sshClient.agentConn != nil {
+			sshClient.agentConn.Close()
+		}
+		var jumpErr *JumpError
+		if errors.As(err, &jumpErr) {
+			return err
+		}
+		if sshClient.hostKeyErr != nil {
+			return sshClient.hostKeyErr
+		}
+		if isAuthRejected(err) {
+			return &AuthError{User: sshClient.User, Address: sshClient.getAddress(), Methods: authNames, Reasons: reasons, Err: err}
+		}
 		return err
 	}
 	sshClient.client = client
 	return nil
 }
 
-// RunCommand function
-func (sshClient *SSH) RunCommand(command string, pipe string) (string, error) {
+// RunCommand function ; returns the stdout and stderr streams separately
+func (sshClient *SSH) RunCommand(command string, pipe string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
 	if pipe != "" {
-		go func() {
-			w, err := sshClient.session.StdinPipe()
-			defer w.Close()
-			if err != nil {
-				fmt.Println(err)
-			}
-			fmt.Fprint(w, pipe)
-		}()
-	}
-	output, err := sshClient.session.CombinedOutput(command)
-	if len(output) > 0 {
-		output = output[:len(output)-1]
-	}
-	if err != nil {
-		return string(output), err
+		sshClient.session.Stdin = strings.NewReader(pipe)
 	}
-	return string(output), nil
+	sshClient.session.Stdout = &stdout
+	sshClient.session.Stderr = &stderr
+	err := sshClient.session.Run(command)
+
+	return strings.TrimSuffix(stdout.String(), "\n"), strings.TrimSuffix(stderr.String(), "\n"), err
 }
 
-// RefreshSession function
-func (sshClient *SSH) RefreshSession() {
+// RefreshSession function ; opens a new session on the host connection
+func (sshClient *SSH) RefreshSession() error {
 	session, err := sshClient.client.NewSession()
 	if err != nil {
-		fmt.Println(err)
-		sshClient.Close()
-		return
+		return err
 	}
 	sshClient.session = session
+	return nil
+}
+
+// CloseSession function ; closes the session and keeps the connection open for the next commands
+func (sshClient *SSH) CloseSession() {
+	if sshClient.session != nil {
+		sshClient.session.Close()
+		sshClient.session = nil
+	}
 }
 
 // Close function
 func (sshClient *SSH) Close() {
-	sshClient.session.Close()
-	sshClient.client.Close()
+	if sshClient.session != nil {
+		sshClient.session.Close()
+	}
+	if sshClient.client != nil {
+		sshClient.client.Close()
+	}
+	if sshClient.agentConn != nil {
+		sshClient.agentConn.Close()
+	}
 }
 
-// CopyFileToRemote function
-func (sshClient *SSH) CopyFileToRemote(file string, remotePath string, permission string) {
+// CopyFileToRemote function ; reuses the authenticated connection of the host
+func (sshClient *SSH) CopyFileToRemote(file string, remotePath string, permission string, timeout int) error {
 
-	// Create a new SCP client
-	client := scp.NewClient(sshClient.Server, sshClient.sshConfig)
-
-	// Connect to the remote server
-	err := client.Connect()
+	// Create a new SCP client on top of the existing connection
+	client, err := scp.NewClientBySSHWithTimeout(sshClient.client, getTransferTimeout(timeout))
 	if err != nil {
-		fmt.Println("Couldn't establish a connection to the remote server ", err)
-		return
+		return err
 	}
 
-	// Open a file
-	f, _ := os.Open(file)
-
-	// Close client connection after the file has been copied
+	// Close the scp session after the file has been copied
 	defer client.Close()
 
+	// Open a file
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
 	// Close the file after it has been copied
 	defer f.Close()
 
 	// Copy the file over
 	// Usage: CopyFile(fileReader, remotePath, permission)
-
-	err = client.CopyFile(f, remotePath, permission)
-
-	if err != nil {
-		fmt.Println("Error while copying file ", err)
-	}
-	sshClient.session.Close()
-	sshClient.client.Close()
+	return client.CopyFile(f, remotePath, permission)
 }
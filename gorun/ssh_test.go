@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInitHostsBecome(t *testing.T) {
+	hostsFile := `
+nodes:
+  - server: "inherits"
+  - server: "opts-out"
+    become: false
+  - server: "opts-in"
+    become: true
+defaults:
+  become: true
+`
+	// the hosts files are looked up from the working folder
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.WriteFile("hosts.yaml", []byte(hostsFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := readHostsYamlFile("hosts.yaml")
+	if err != nil || len(nodes) != 3 {
+		t.Fatalf("readHostsYamlFile = %v nodes, %v", len(nodes), err)
+	}
+	expected := map[string]bool{"inherits": true, "opts-out": false, "opts-in": true}
+	for _, node := range nodes {
+		node.Client.initHosts()
+		become := isBecome(Command{}, node.Client)
+		if become != expected[node.Client.Server] {
+			t.Errorf("%v: become = %v, expected %v", node.Client.Server, become, expected[node.Client.Server])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sshConfigBlock is a Host block of an ssh_config file
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string][]string
+}
+
+// SSHConfigFile pre-defined struct ; the Host blocks of the user's ssh_config, in file order
+// ------------------------------------
+type SSHConfigFile struct {
+	blocks []sshConfigBlock
+}
+
+// UserSSHConfig global instance containing the ssh_config file set in Config.SSHConfigFile
+var UserSSHConfig *SSHConfigFile
+
+// readSSHConfigFile parses an ssh_config file, following its Include directives.
+// Match blocks are not supported and are skipped.
+func readSSHConfigFile(fileName string) (*SSHConfigFile, error) {
+	config := &SSHConfigFile{}
+	err := config.read(expandHome(fileName), 0)
+	return config, err
+}
+
+func (config *SSHConfigFile) read(fileName string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("error: too many nested Include in %v", fileName)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the options before the first Host line apply to all the hosts
+	current := &sshConfigBlock{patterns: []string{"*"}, options: make(map[string][]string)}
+	config.blocks = append(config.blocks, *current)
+	currentIndex := len(config.blocks) - 1
+	skip := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value := splitSSHConfigLine(line)
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			config.blocks = append(config.blocks, sshConfigBlock{patterns: strings.Fields(value), options: make(map[string][]string)})
+			currentIndex = len(config.blocks) - 1
+			skip = false
+
+		case "match":
+			skip = true
+
+		case "include":
+			// the files included by a skipped Match block are skipped with it
+			if skip {
+				continue
+			}
+			for _, pattern := range strings.Fields(value) {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(expandHome("~/.ssh"), pattern)
+				}
+				files, _ := filepath.Glob(pattern)
+				for _, include := range files {
+					if err := config.read(include, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+
+		default:
+			if skip {
+				continue
+			}
+			block := config.blocks[currentIndex]
+			block.options[key] = append(block.options[key], value)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitSSHConfigLine splits "Keyword value" and "Keyword=value" lines, the keyword being case insensitive
+func splitSSHConfigLine(line string) (string, string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	key := strings.ToLower(line[:i])
+	value := strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	value = strings.Trim(value, `"`)
+	return key, value
+}
+
+// matchSSHConfigPatterns tells if a Host line applies to the host, with support for * ? and ! negations
+func matchSSHConfigPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(host))
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// Get returns the first value obtained for the keyword, the way ssh reads its configuration
+func (config *SSHConfigFile) Get(host string, key string) string {
+	if config == nil {
+		return ""
+	}
+	key = strings.ToLower(key)
+	for _, block := range config.blocks {
+		if values, ok := block.options[key]; ok && matchSSHConfigPatterns(block.patterns, host) {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// getConcreteHosts returns the Host aliases without wildcards or negations
+func (config *SSHConfigFile) getConcreteHosts() []string {
+	var hosts []string
+	if config == nil {
+		return hosts
+	}
+	seen := make(map[string]bool)
+	for _, block := range config.blocks {
+		for _, pattern := range block.patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			hosts = append(hosts, pattern)
+		}
+	}
+	return hosts
+}
+
+// expandSSHConfigTokens replaces the %h, %p, %r and %% tokens and the leading ~ of ssh_config values
+func expandSSHConfigTokens(value string, sshClient SSH) string {
+	replacer := strings.NewReplacer("%%", "%", "%h", sshClient.getHostName(), "%p", sshClient.Port, "%r", sshClient.User)
+	return expandHome(replacer.Replace(value))
+}
+
+// applySSHConfig fills the host values set neither in its hosts file node nor in the file defaults
+// from the user's ssh_config ; returns true when the identity file comes from ssh_config
+func (sshClient *SSH) applySSHConfig() bool {
+	alias := sshClient.Server
+	if UserSSHConfig == nil || alias == "" {
+		return false
+	}
+	if hostName := UserSSHConfig.Get(alias, "HostName"); hostName != "" && sshClient.hostName == "" {
+		sshClient.hostName = strings.ReplaceAll(hostName, "%h", alias)
+	}
+	if sshClient.User == "" {
+		sshClient.User = UserSSHConfig.Get(alias, "User")
+	}
+	if sshClient.Port == "" {
+		sshClient.Port = UserSSHConfig.Get(alias, "Port")
+	}
+	if sshClient.Jump == "" {
+		sshClient.Jump = UserSSHConfig.Get(alias, "ProxyJump")
+	}
+	if sshClient.Key == "" {
+		if identityFile := UserSSHConfig.Get(alias, "IdentityFile"); identityFile != "" {
+			sshClient.Key = expandSSHConfigTokens(identityFile, *sshClient)
+			return true
+		}
+	}
+	return false
+}
+
+// readSSHConfigNodes imports every concrete Host of the user's ssh_config as a node
+func readSSHConfigNodes() Nodes {
+	var nodes Nodes
+	for _, host := range UserSSHConfig.getConcreteHosts() {
+		var node Node
+		node.Client.Server = host
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSSHConfigFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	files := map[string]string{
+		".ssh/config": `
+# the options before the first Host apply to all the hosts
+ConnectTimeout 5
+
+Host web* !web3
+  User deploy
+  Port 2222
+
+Host web1
+  HostName 10.0.0.1
+  User other
+
+Match host db*
+  User matched
+  Include matched/*
+
+Host db1 db2
+  HostName=db1.example.com
+  IdentityFile "~/.ssh/%h_key"
+
+Include conf.d/*
+`,
+		".ssh/conf.d/bastion": `
+Host bastion
+  ProxyJump jump@gw:22
+  user ops
+`,
+		".ssh/matched/db": `
+Host db3
+`,
+	}
+	for name, content := range files {
+		file := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := readSSHConfigFile("~/.ssh/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host  string
+		key   string
+		value string
+	}{
+		{"web1", "User", "deploy"},
+		{"web1", "Port", "2222"},
+		{"web1", "HostName", "10.0.0.1"},
+		{"WEB2", "user", "deploy"},
+		{"web3", "User", ""},
+		{"web3", "ConnectTimeout", "5"},
+		{"db1", "User", ""},
+		{"db2", "HostName", "db1.example.com"},
+		{"db1", "IdentityFile", "~/.ssh/%h_key"},
+		{"bastion", "ProxyJump", "jump@gw:22"},
+		{"bastion", "User", "ops"},
+		{"unknown", "HostName", ""},
+	}
+	for _, test := range tests {
+		if value := config.Get(test.host, test.key); value != test.value {
+			t.Errorf("Get(%q, %q) = %q, expected %q", test.host, test.key, value, test.value)
+		}
+	}
+
+	if hosts := config.getConcreteHosts(); !reflect.DeepEqual(hosts, []string{"web1", "db1", "db2", "bastion"}) {
+		t.Errorf("getConcreteHosts() = %q", hosts)
+	}
+
+	UserSSHConfig = config
+	defer func() { UserSSHConfig = nil }()
+	sshClient := SSH{Server: "db1", User: "root"}
+	if !sshClient.applySSHConfig() || sshClient.getHostName() != "db1.example.com" || sshClient.User != "root" ||
+		sshClient.Key != filepath.Join(home, ".ssh/db1.example.com_key") {
+		t.Errorf("applySSHConfig(db1) = hostname %v, user %v, key %v", sshClient.getHostName(), sshClient.User, sshClient.Key)
+	}
+	sshClient = SSH{Server: "bastion"}
+	if sshClient.applySSHConfig() || sshClient.Jump != "jump@gw:22" || sshClient.User != "ops" || sshClient.Port != "" {
+		t.Errorf("applySSHConfig(bastion) = jump %v, user %v, port %v", sshClient.Jump, sshClient.User, sshClient.Port)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// getTemplateData returns the values a command template is rendered with ; the host vars, the command parameters
+// and the built-ins .Server, .Port, .User, .Group and .Index, the position of the host in the matched hosts
+func getTemplateData(node Node, index int, params map[string]string) map[string]interface{} {
+	data := make(map[string]interface{})
+	for name, value := range node.Client.Vars {
+		data[name] = value
+	}
+	for name, value := range params {
+		data[name] = value
+	}
+	data["Server"] = node.Client.Server
+	data["Port"] = node.Client.Port
+	data["User"] = node.Client.User
+	data["Group"] = node.Group
+	data["Index"] = index
+	return data
+}
+
+// renderCommand renders the command text as a Go template for the host ; an undefined variable is an error
+func renderCommand(text string, node Node, index int, params map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error: invalid command template: %v", err)
+	}
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, getTemplateData(node, index, params))
+	if err != nil {
+		message := fmt.Sprintf("error: cannot render the command for %v: %v", getHostLabel(node.Client), err)
+		// the hosts files keys are read lowercase, hint at the var the template probably meant
+		if _, key, ok := strings.Cut(err.Error(), "map has no entry for key "); ok {
+			key = strings.Trim(key, `"`)
+			if _, exists := node.Client.Vars[strings.ToLower(key)]; exists && key != strings.ToLower(key) {
+				message = fmt.Sprintf("%v ; the vars names are read lowercase, use {{.%v}}", message, strings.ToLower(key))
+			}
+		}
+		return "", fmt.Errorf("%v", message)
+	}
+	return rendered.String(), nil
+}
+
+// escapeTemplate makes the text a literal part of a template, a command line argument added to a commands file command
+func escapeTemplate(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
+
+// renderCommandOnHosts renders the command and its args for every host before any of them runs it ; the one time
+// commands, such as docker ps --format '{{.Names}}', are not templates and run as typed
+func renderCommandOnHosts(command Command, sshClients Nodes) error {
+	if !command.Template {
+		for i := range sshClients {
+			sshClients[i].Command = command.Command
+			if command.Args != "" {
+				sshClients[i].Command = command.Command + " " + command.Args
+			}
+		}
+		return nil
+	}
+	for i := range sshClients {
+		runCommand, err := renderCommand(command.Command, sshClients[i], i, command.ParamValues)
+		if err != nil {
+			return err
+		}
+		if command.Args != "" {
+			args, err := renderCommand(command.Args, sshClients[i], i, command.ParamValues)
+			if err != nil {
+				return err
+			}
+			runCommand = runCommand + " " + args
+		}
+		sshClients[i].Command = runCommand
+	}
+	return nil
+}
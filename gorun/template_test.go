@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderCommandOnHosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  Command
+		rendered string
+		fails    bool
+	}{
+		{"one time command", Command{Command: "docker ps --format '{{.Names}}'"}, "docker ps --format '{{.Names}}'", false},
+		{"one time args", Command{Command: "echo", Args: "{{.Server}}"}, "echo {{.Server}}", false},
+		{"template", Command{Command: "echo {{.Server}}:{{.Port}} {{.dc}}", Template: true}, "echo web1:22 rtp", false},
+		{"template args", Command{Command: "echo", Args: "{{.Index}}", Template: true}, "echo 0", false},
+		{"escaped", Command{Command: `docker ps --format '{{"{{"}}.Names}}'`, Template: true}, "docker ps --format '{{.Names}}'", false},
+		{"escaped args", Command{Command: "docker ps", Args: escapeTemplate("--format '{{.Names}}'"), Template: true},
+			"docker ps --format '{{.Names}}'", false},
+		{"undefined var", Command{Command: "echo {{.nope}}", Template: true}, "", true},
+		{"invalid template", Command{Command: "echo {{.Server", Template: true}, "", true},
+	}
+	for _, test := range tests {
+		var node Node
+		node.Client.Server, node.Client.Port = "web1", "22"
+		node.Client.Vars = map[string]string{"dc": "rtp"}
+		nodes := Nodes{node}
+		err := renderCommandOnHosts(test.command, nodes)
+		if test.fails != (err != nil) {
+			t.Errorf("%v: renderCommandOnHosts error = %v, expected failure %v", test.name, err, test.fails)
+			continue
+		}
+		if !test.fails && nodes[0].Command != test.rendered {
+			t.Errorf("%v: rendered %q, expected %q", test.name, nodes[0].Command, test.rendered)
+		}
+	}
+}
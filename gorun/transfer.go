@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transfer pre-defined struct
+// ------------------------------------
+type Transfer struct {
+	Direction   string
+	Source      string
+	Destination string
+	Mode        string
+}
+
+// Transfer directions
+const (
+	TransferPush = "push"
+	TransferPull = "pull"
+)
+
+// shellQuote quotes a string so the remote shell takes it as a single word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runTransfer runs a push or a pull on an already connected host and returns the list of copied files
+func (sshClient *SSH) runTransfer(transfer Transfer, timeout int) (string, error) {
+	var files []string
+	var err error
+	if transfer.Direction == TransferPush {
+		files, err = sshClient.CopyPathToRemote(transfer.Source, transfer.Destination, transfer.Mode, timeout)
+	} else {
+		localDir := filepath.Join(transfer.Destination, strings.ReplaceAll(getHostLabel(*sshClient), ":", "_"))
+		files, err = sshClient.CopyPathFromRemote(transfer.Source, localDir)
+	}
+	return strings.Join(files, "\n"), err
+}
+
+// CopyPathToRemote copies a local file, or the content of a local folder recursively, to the remote path
+func (sshClient *SSH) CopyPathToRemote(localPath string, remotePath string, permission string, timeout int) ([]string, error) {
+	var files []string
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return files, err
+	}
+	if !info.IsDir() {
+		if permission == "" {
+			permission = fmt.Sprintf("%04o", info.Mode().Perm())
+		}
+		err = sshClient.CopyFileToRemote(localPath, remotePath, permission, timeout)
+		if err != nil {
+			return files, err
+		}
+		return append(files, fmt.Sprintf("%v -> %v", localPath, remotePath)), nil
+	}
+
+	err = filepath.Walk(localPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(localPath, file)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remotePath, filepath.ToSlash(relativePath))
+		if info.IsDir() {
+			return sshClient.runSimpleCommand(fmt.Sprintf("mkdir -p %v", shellQuote(target)))
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		mode := permission
+		if mode == "" {
+			mode = fmt.Sprintf("%04o", info.Mode().Perm())
+		}
+		err = sshClient.CopyFileToRemote(file, target, mode, timeout)
+		if err != nil {
+			return fmt.Errorf("%v: %v", file, err)
+		}
+		files = append(files, fmt.Sprintf("%v -> %v", file, target))
+		return nil
+	})
+
+	return files, err
+}
+
+// runSimpleCommand runs a helper command on its own session and returns its stderr as error on failure
+func (sshClient *SSH) runSimpleCommand(command string) error {
+	session, err := sshClient.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Run(command)
+	if err != nil && stderr.Len() > 0 {
+		return errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// CopyPathFromRemote copies a remote file or folder recursively into the local folder,
+// speaking the sink side of the scp protocol
+func (sshClient *SSH) CopyPathFromRemote(remotePath string, localDir string) ([]string, error) {
+	var files []string
+	err := os.MkdirAll(localDir, 0755)
+	if err != nil {
+		return files, err
+	}
+
+	session, err := sshClient.client.NewSession()
+	if err != nil {
+		return files, err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return files, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return files, err
+	}
+	err = session.Start(fmt.Sprintf("scp -rf %v", shellQuote(remotePath)))
+	if err != nil {
+		return files, err
+	}
+
+	files, err = scpReceive(bufio.NewReader(stdout), stdin, localDir)
+	stdin.Close()
+	waitErr := session.Wait()
+	if err == nil && waitErr != nil {
+		err = waitErr
+		if stderr.Len() > 0 {
+			err = errors.New(strings.TrimSpace(stderr.String()))
+		}
+	}
+	return files, err
+}
+
+// scpReceive reads the scp source messages and writes the received files and folders under localDir
+func scpReceive(reader *bufio.Reader, writer io.Writer, localDir string) ([]string, error) {
+	var files []string
+	folders := []string{localDir}
+	ack := func() error {
+		_, err := writer.Write([]byte{0})
+		return err
+	}
+
+	if err := ack(); err != nil {
+		return files, err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if len(line) == 0 {
+			return files, errors.New("error: empty scp message")
+		}
+		current := folders[len(folders)-1]
+
+		switch line[0] {
+		case 1, 2:
+			return files, errors.New(line[1:])
+
+		case 'T':
+
+		case 'E':
+			if len(folders) == 1 {
+				return files, errors.New("error: unexpected end of folder in scp stream")
+			}
+			folders = folders[:len(folders)-1]
+
+		case 'C', 'D':
+			fields := strings.SplitN(line[1:], " ", 3)
+			if len(fields) != 3 {
+				return files, fmt.Errorf("error: invalid scp message '%v'", line)
+			}
+			mode, err := strconv.ParseUint(fields[0], 8, 32)
+			if err != nil {
+				return files, fmt.Errorf("error: invalid scp mode '%v'", fields[0])
+			}
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return files, fmt.Errorf("error: invalid scp size '%v'", fields[1])
+			}
+			name := fields[2]
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+				return files, fmt.Errorf("error: refusing unsafe file name '%v'", name)
+			}
+			target := filepath.Join(current, name)
+
+			if line[0] == 'D' {
+				err = os.MkdirAll(target, os.FileMode(mode)|0700)
+				if err != nil {
+					return files, err
+				}
+				folders = append(folders, target)
+				break
+			}
+
+			if err := ack(); err != nil {
+				return files, err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(mode))
+			if err != nil {
+				return files, err
+			}
+			_, err = io.CopyN(file, reader, size)
+			file.Close()
+			if err != nil {
+				return files, err
+			}
+			status, err := reader.ReadByte()
+			if err != nil {
+				return files, err
+			}
+			if status != 0 {
+				return files, fmt.Errorf("error: remote scp failed while sending '%v'", name)
+			}
+			files = append(files, target)
+
+		default:
+			return files, fmt.Errorf("error: unexpected scp message '%v'", line)
+		}
+
+		if err := ack(); err != nil {
+			return files, err
+		}
+	}
+}
+
+// getTransferTimeout returns the scp timeout for a command, falling back to Config.CommandDefaultTimeout
+func getTransferTimeout(timeout int) time.Duration {
+	if timeout <= 0 {
+		timeout = Config.CommandDefaultTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScpReceive(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		files  []string
+		fails  bool
+	}{
+		{"file", "C0644 5 a.txt\nhello\x00", []string{"a.txt"}, false},
+		{"folder", "D0755 0 logs\nC0600 2 b.log\nhi\x00E\n", []string{"logs/b.log"}, false},
+		{"times", "T1 0 1 0\nC0644 0 c\n\x00", []string{"c"}, false},
+		{"empty stream", "", nil, false},
+		{"empty line", "\n", nil, true},
+		{"remote error", "\x01scp: /nope: No such file or directory\n", nil, true},
+		{"unsafe name", "C0644 1 ../x\nx\x00", nil, true},
+		{"end of root", "E\n", nil, true},
+		{"bad mode", "C0x44 1 a\nx\x00", nil, true},
+		{"short message", "C0644 1\n", nil, true},
+		{"unknown message", "X\n", nil, true},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		var acks bytes.Buffer
+		files, err := scpReceive(bufio.NewReader(strings.NewReader(test.stream)), &acks, dir)
+		if test.fails != (err != nil) {
+			t.Errorf("%v: scpReceive error = %v, expected failure %v", test.name, err, test.fails)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		if len(files) != len(test.files) {
+			t.Errorf("%v: scpReceive files = %v, expected %v", test.name, files, test.files)
+			continue
+		}
+		for i, file := range test.files {
+			if files[i] != filepath.Join(dir, file) {
+				t.Errorf("%v: scpReceive file %v = %v, expected %v", test.name, i, files[i], filepath.Join(dir, file))
+			}
+			if _, err := os.Stat(files[i]); err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+		}
+	}
+}
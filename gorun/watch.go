@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchHeader is the table header of the watched commands which do not define their own
+const watchHeader = "HOST\tRC\tOUTPUT"
+
+// WatchInterval is the delay between two runs of a watched command, set by --watch
+var WatchInterval time.Duration
+
+// getWatchInterval parses the --watch value, a duration (2s, 1m) or a number of seconds
+func getWatchInterval(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("error: invalid --watch value '%v'", value)
+	}
+	return interval, nil
+}
+
+// getWatchRows returns the table rows of the hosts, split in cells ; the command output lines when it has
+// a header, else one row per output line prefixed with the host. Failed hosts get a row with their status.
+func getWatchRows(command Command, sshClients Nodes) [][]string {
+	var rows [][]string
+	for _, node := range sshClients {
+		label := getHostLabel(node.Client)
+		if isConnectionFailure(node.Result.Status) {
+			rows = append(rows, []string{label, node.Result.Status, node.Result.Error})
+			continue
+		}
+		output := getRawOutput(node.Result)
+		if command.Header != "" && node.Result.Status == StatusPassed {
+			for _, line := range strings.Split(node.Result.Stdout, "\n") {
+				rows = append(rows, strings.Split(line, "\t"))
+			}
+			continue
+		}
+		for _, line := range strings.Split(output, "\n") {
+			rows = append(rows, []string{label, getResultCode(node.Result), line})
+		}
+	}
+	return rows
+}
+
+// getWatchTable renders the rows, highlighting the cells which changed since the previous run ;
+// every cell gets a color of the same length to keep the table aligned
+func getWatchTable(header string, rows [][]string, previous [][]string) []string {
+	var lines []string
+	var headerCells []string
+	for _, cell := range strings.Split(header, "\t") {
+		headerCells = append(headerCells, Default(cell))
+	}
+	lines = append(lines, strings.Join(headerCells, "\t"))
+	for i, row := range rows {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			changed := previous != nil && (i >= len(previous) || j >= len(previous[i]) || previous[i][j] != cell)
+			if changed {
+				cells[j] = Reverse(cell)
+			} else {
+				cells[j] = Default(cell)
+			}
+		}
+		lines = append(lines, strings.Join(cells, "\t"))
+	}
+	return lines
+}
+
+// watchCommandOnHosts runs the command on the hosts every WatchInterval and redraws its table in place,
+// until interrupted ; the connections stay open between the runs and the unreachable hosts are retried
+func watchCommandOnHosts(command Command, sshClients Nodes) {
+	header := command.Header
+	if header == "" {
+		header = watchHeader
+	}
+	// a header keeps runCommandOnBatch from printing the per host banners
+	watched := command
+	watched.Header = header
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	err := renderCommandOnHosts(command, sshClients)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+
+	var previous [][]string
+	for {
+		for i := range sshClients {
+			sshClients[i].Result = CommandResult{}
+			sshClients[i].Output = ""
+		}
+		t1 := time.Now()
+		runCommandOnBatch(watched, sshClients)
+		duration := time.Now().Sub(t1)
+
+		rows := getWatchRows(command, sshClients)
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%v\n\n", Yellow(fmt.Sprintf("%vevery %v: %v | %v | duration: %0.2vs | ctrl-c to stop",
+			getEnvBannerPart(), WatchInterval, strings.ReplaceAll(command.Name, "\n", " "), t1.Format("15:04:05"), duration.Seconds())))
+		printTabbedTable(getWatchTable(header, rows, previous))
+		previous = rows
+
+		select {
+		case <-interrupt:
+			fmt.Println()
+			return
+		case <-time.After(WatchInterval):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetWatchInterval(t *testing.T) {
+	tests := []struct {
+		value    string
+		interval time.Duration
+		fails    bool
+	}{
+		{"2", 2 * time.Second, false},
+		{"2s", 2 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"1m30s", 90 * time.Second, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"-1s", 0, true},
+		{"", 0, true},
+		{"often", 0, true},
+	}
+	for _, test := range tests {
+		interval, err := getWatchInterval(test.value)
+		if (err != nil) != test.fails || interval != test.interval {
+			t.Errorf("getWatchInterval(%q) = %v, %v, expected %v", test.value, interval, err, test.interval)
+		}
+	}
+}
+
+func TestGetWatchRows(t *testing.T) {
+	nodes := Nodes{
+		{Client: SSH{Server: "web1", Port: "22"}, Result: CommandResult{Stdout: "web1\t12%\nweb1-data\t40%", Status: StatusPassed}},
+		{Client: SSH{Server: "web2", Port: "2222"}, Result: CommandResult{Stderr: "df: not found", ReturnCode: 127, Status: StatusFailed}},
+		{Client: SSH{Server: "web3", Port: "22"}, Result: CommandResult{ReturnCode: -1, Status: StatusUnreachable, Error: "connection refused"}},
+	}
+	tests := []struct {
+		header string
+		rows   [][]string
+	}{
+		{"", [][]string{
+			{"web1", "0", "web1\t12%"},
+			{"web1", "0", "web1-data\t40%"},
+			{"web2:2222", "127", "df: not found"},
+			{"web3", StatusUnreachable, "connection refused"},
+		}},
+		{"HOST\tUSE", [][]string{
+			{"web1", "12%"},
+			{"web1-data", "40%"},
+			{"web2:2222", "127", "df: not found"},
+			{"web3", StatusUnreachable, "connection refused"},
+		}},
+	}
+	for _, test := range tests {
+		rows := getWatchRows(Command{Header: test.header}, nodes)
+		if !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("getWatchRows with header %q = %q, expected %q", test.header, rows, test.rows)
+		}
+	}
+}
+
+func TestGetWatchTable(t *testing.T) {
+	// the changed and unchanged cells take the same width in the aligned table
+	if len(Default("")) != len(Reverse("")) {
+		t.Errorf("the Default and Reverse colors differ in length, misaligning the table")
+	}
+	rows := [][]string{{"web1", "0", "12%"}, {"web2", "0", "40%"}}
+	tests := []struct {
+		previous [][]string
+		reversed []string
+	}{
+		{nil, nil},
+		{[][]string{{"web1", "0", "12%"}, {"web2", "0", "40%"}}, nil},
+		{[][]string{{"web1", "0", "11%"}, {"web2", "0", "40%"}}, []string{"12%"}},
+		{[][]string{{"web1", "1", "12%"}}, []string{"0", "web2", "0", "40%"}},
+	}
+	for _, test := range tests {
+		lines := getWatchTable(watchHeader, rows, test.previous)
+		if len(lines) != 3 || lines[0] != strings.Join([]string{Default("HOST"), Default("RC"), Default("OUTPUT")}, "\t") {
+			t.Fatalf("getWatchTable = %q", lines)
+		}
+		var reversed []string
+		for _, line := range lines[1:] {
+			for _, cell := range strings.Split(line, "\t") {
+				if strings.HasPrefix(cell, "\033[1;7m") {
+					reversed = append(reversed, strings.TrimSuffix(strings.TrimPrefix(cell, "\033[1;7m"), "\033[0m"))
+				}
+			}
+		}
+		if !reflect.DeepEqual(reversed, test.reversed) {
+			t.Errorf("getWatchTable after %q reversed %q, expected %q", test.previous, reversed, test.reversed)
+		}
+	}
+}